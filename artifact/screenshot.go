@@ -0,0 +1,66 @@
+package artifact
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"selenium-hub/proxy"
+	"selenium-hub/translator"
+)
+
+// Screenshots stores the last screenshot of every session in a directory.
+type Screenshots struct {
+	directory string
+	timeout   time.Duration
+}
+
+func NewScreenshots(directory string, timeout time.Duration) (*Screenshots, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+	return &Screenshots{directory, timeout}, nil
+}
+
+// Capture asks the node for a screenshot of the session and saves it as PNG.
+// The node is given the timeout of screenshots to answer.
+func (screenshots *Screenshots) Capture(ctx context.Context, nodeUrl string, sessionId string) error {
+	if screenshots.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, screenshots.timeout)
+		defer cancel()
+	}
+	var r *http.Request = new(http.Request)
+	r.Method = "GET"
+	r.RequestURI = "/wd/hub/session/" + sessionId + "/screenshot"
+	data, status, err := proxy.ProxyRequestContext(ctx, nodeUrl, r, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("node answered %d on screenshot request", status)
+	}
+	png, err := translator.GetScreenshot(data)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(screenshots.Path(sessionId), png, 0644)
+}
+
+// Path returns a file where a screenshot of the session is stored.
+func (screenshots *Screenshots) Path(sessionId string) string {
+	return filepath.Join(screenshots.directory, filepath.Base(sessionId)+".png")
+}
+
+// Find returns a path to the screenshot of the session if it was captured.
+func (screenshots *Screenshots) Find(sessionId string) (string, bool) {
+	path := screenshots.Path(sessionId)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
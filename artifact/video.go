@@ -0,0 +1,71 @@
+package artifact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Video controls a recorder sidecar which runs next to a node.
+type Video struct {
+	url    string
+	client *http.Client
+}
+
+type videoRequest struct {
+	SessionId string `json:"sessionId"`
+}
+
+type videoAnswer struct {
+	Url string `json:"url"`
+}
+
+func NewVideo(sidecarUrl string, timeout time.Duration) *Video {
+	return &Video{sidecarUrl, &http.Client{Timeout: timeout}}
+}
+
+// Start begins recording of the session on the node host.
+func (video *Video) Start(nodeUrl string, sessionId string) error {
+	_, err := video.call(nodeUrl, "/start", sessionId)
+	return err
+}
+
+// Stop finishes recording and returns a link to the video.
+func (video *Video) Stop(nodeUrl string, sessionId string) (string, error) {
+	answer, err := video.call(nodeUrl, "/stop", sessionId)
+	if err != nil {
+		return "", err
+	}
+	return answer.Url, nil
+}
+
+func (video *Video) call(nodeUrl string, action string, sessionId string) (*videoAnswer, error) {
+	node, err := url.Parse(nodeUrl)
+	if err != nil {
+		return nil, err
+	}
+	sidecarUrl := strings.Replace(video.url, "{host}", node.Hostname(), -1)
+	data, _ := json.Marshal(videoRequest{sessionId})
+	response, err := video.client.Post(strings.TrimRight(sidecarUrl, "/")+action, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	data, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 300 {
+		return nil, fmt.Errorf("recorder answered %d: %s", response.StatusCode, strings.TrimSpace(string(data)))
+	}
+	answer := &videoAnswer{}
+	if len(data) > 0 {
+		json.Unmarshal(data, answer)
+	}
+	return answer, nil
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"expvar"
+	"net/http"
+	"os"
+	"strings"
+
+	"selenium-hub/logger"
+)
+
+var log = logger.For("auth")
+
+type contextKey uint8
+
+const identityKey contextKey = iota
+
+// Kinds of protected endpoints.
+const (
+	Client       = "client"
+	Registration = "registration"
+	Admin        = "admin"
+)
+
+// Rejected counts rejected requests by kind of endpoint.
+var Rejected = expvar.NewMap("rejected_requests")
+
+// Reject logs and counts a request which was not authenticated.
+func Reject(kind string, r *http.Request, reason string) {
+	Rejected.Add(kind, 1)
+	log.WarnContext(r.Context(), "Request rejected", "kind", kind, "remote", r.RemoteAddr,
+		"method", r.Method, "path", r.URL.Path, "reason", reason)
+}
+
+// Authenticator recognizes a client by request credentials.
+type Authenticator interface {
+	// Authenticate returns an identity of the client. Found is false when the request
+	// has no credentials this authenticator understands or they are wrong.
+	Authenticate(r *http.Request) (identity string, found bool)
+}
+
+// Chain tries authenticators in order and returns the first identity.
+type Chain []Authenticator
+
+func (chain Chain) Authenticate(r *http.Request) (string, bool) {
+	for _, authenticator := range chain {
+		if identity, found := authenticator.Authenticate(r); found {
+			return identity, true
+		}
+	}
+	return "", false
+}
+
+// Basic checks HTTP basic credentials. Passwords are stored in plain text or as "sha256:" and hex digest.
+type Basic struct {
+	users map[string]string
+}
+
+// LoadBasic reads a credentials file with "user:password" lines. Empty lines and lines starting with # are skipped.
+func LoadBasic(path string) (*Basic, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	basic := &Basic{make(map[string]string)}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if user, password, found := strings.Cut(line, ":"); found {
+			basic.users[user] = password
+		}
+	}
+	return basic, scanner.Err()
+}
+
+func (basic *Basic) Authenticate(r *http.Request) (string, bool) {
+	user, password, found := r.BasicAuth()
+	if !found {
+		return "", false
+	}
+	stored, found := basic.users[user]
+	if !found {
+		return "", false
+	}
+	if digest, hashed := strings.CutPrefix(stored, "sha256:"); hashed {
+		sum := sha256.Sum256([]byte(password))
+		password = hex.EncodeToString(sum[:])
+		stored = strings.ToLower(digest)
+	}
+	if subtle.ConstantTimeCompare([]byte(stored), []byte(password)) != 1 {
+		return "", false
+	}
+	return user, true
+}
+
+// Tokens checks bearer API tokens. Every token maps to an identity. Browsers can not set headers
+// of WebSocket connections, so a token of the VNC viewer is also accepted in access_token query parameter.
+type Tokens map[string]string
+
+// queryTokenPath is the only path prefix where a token is accepted in the query, where it may leak to logs.
+const queryTokenPath = "/grid/vnc/"
+
+func (tokens Tokens) Authenticate(r *http.Request) (string, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		if !strings.HasPrefix(r.URL.Path, queryTokenPath) {
+			return "", false
+		}
+		token = r.URL.Query().Get("access_token")
+		if token == "" {
+			return "", false
+		}
+	}
+	for known, identity := range tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(strings.TrimSpace(token))) == 1 {
+			return identity, true
+		}
+	}
+	return "", false
+}
+
+// Middleware rejects requests which are not authenticated and stores the identity in the request context.
+// Kind is used to count rejected requests.
+func Middleware(kind string, authenticator Authenticator, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, found := authenticator.Authenticate(r)
+		if !found {
+			Reject(kind, r, "invalid credentials")
+			if kind == Client {
+				w.Header().Set("WWW-Authenticate", `Basic realm="selenium-hub"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="selenium-hub"`)
+			}
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey, identity)))
+	})
+}
+
+// Identity returns the identity of an authenticated client.
+func Identity(ctx context.Context) string {
+	identity, _ := ctx.Value(identityKey).(string)
+	return identity
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// SecretHeader may carry the registration secret when a node can not put it in its configuration.
+const SecretHeader = "X-Registration-Secret"
+
+// NodeRegistration checks that a node is allowed to register. A node is accepted when it sends
+// the shared secret or presents a client certificate verified by the hub TLS configuration.
+type NodeRegistration struct {
+	secret            string
+	clientCertificate bool
+}
+
+func NewNodeRegistration(secret string, clientCertificate bool) *NodeRegistration {
+	return &NodeRegistration{secret, clientCertificate}
+}
+
+// Allow reports whether the registration request is accepted. Secret is the one sent in the node configuration.
+func (registration *NodeRegistration) Allow(r *http.Request, secret string) bool {
+	if registration.clientCertificate && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	if registration.secret != "" {
+		if secret == "" {
+			secret = r.Header.Get(SecretHeader)
+		}
+		if subtle.ConstantTimeCompare([]byte(registration.secret), []byte(secret)) == 1 {
+			return true
+		}
+	}
+	Reject(Registration, r, "no valid secret or client certificate")
+	return false
+}
@@ -0,0 +1,115 @@
+package certificate
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"selenium-hub/logger"
+)
+
+var log = logger.For("tls")
+
+// Reloader keeps a key pair loaded and reloads it when the files change on disk.
+type Reloader struct {
+	certFile    string
+	keyFile     string
+	certificate *tls.Certificate
+	modified    time.Time
+	locker      *sync.RWMutex
+}
+
+// NewReloader loads the key pair and checks the files for changes every interval.
+func NewReloader(certFile string, keyFile string, interval time.Duration) (*Reloader, error) {
+	var reloader *Reloader = new(Reloader)
+	reloader.certFile = certFile
+	reloader.keyFile = keyFile
+	reloader.locker = new(sync.RWMutex)
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+	go reloader.watch(interval)
+	return reloader, nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate of a server.
+func (reloader *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.locker.RLock()
+	defer reloader.locker.RUnlock()
+	return reloader.certificate, nil
+}
+
+// GetClientCertificate is used as tls.Config.GetClientCertificate of a client.
+func (reloader *Reloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	reloader.locker.RLock()
+	defer reloader.locker.RUnlock()
+	return reloader.certificate, nil
+}
+
+func (reloader *Reloader) load() error {
+	modified, err := reloader.lastModified()
+	if err != nil {
+		return err
+	}
+	certificate, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return err
+	}
+	reloader.locker.Lock()
+	defer reloader.locker.Unlock()
+	reloader.certificate = &certificate
+	reloader.modified = modified
+	return nil
+}
+
+func (reloader *Reloader) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		modified, err := reloader.lastModified()
+		if err != nil {
+			log.Warn("Could not check certificate", "certificate", reloader.certFile, "error", err)
+			continue
+		}
+		reloader.locker.RLock()
+		changed := modified.After(reloader.modified)
+		reloader.locker.RUnlock()
+		if !changed {
+			continue
+		}
+		if err = reloader.load(); err != nil {
+			log.Error("Could not reload certificate, keep the previous one", "certificate", reloader.certFile,
+				"error", err)
+		} else {
+			log.Info("Certificate reloaded", "certificate", reloader.certFile)
+		}
+	}
+}
+
+func (reloader *Reloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{reloader.certFile, reloader.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// LoadPool reads PEM certificates of trusted authorities.
+func LoadPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
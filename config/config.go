@@ -0,0 +1,381 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"selenium-hub/session"
+	"selenium-hub/translator"
+	"time"
+)
+
+// Duration is a time.Duration which is written in configuration as a string like "30s" or "5m".
+type Duration struct {
+	time.Duration
+}
+
+func (duration *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	duration.Duration = parsed
+	return nil
+}
+
+type Config struct {
+	Address             string   `json:"address"`
+	SessionTimeout      Duration `json:"sessionTimeout"`
+	NodeTimeout         Duration `json:"nodeTimeout"`
+	HealthCheckInterval Duration `json:"healthCheckInterval"`
+	// ShutdownTimeout is how long the hub waits for active sessions on SIGTERM before it deletes them.
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+	// NewSessionWaitTimeout is how long a new session request waits for a free slot. Zero means forever.
+	NewSessionWaitTimeout Duration `json:"newSessionWaitTimeout"`
+	// MaxBodySize limits request bodies in bytes, e.g. files uploaded to browsers. Zero means no limit.
+	MaxBodySize int64 `json:"maxBodySize"`
+	Log                   Log      `json:"log"`
+	// Recorder enables per-session command audit log.
+	Recorder *Recorder `json:"recorder"`
+	// Screenshots enables a final screenshot of every session.
+	Screenshots *Screenshots `json:"screenshots"`
+	// Video enables recording through a sidecar on node hosts.
+	Video *Video `json:"video"`
+	// ArtifactRetention is how long links to session artifacts are kept.
+	ArtifactRetention Duration   `json:"artifactRetention"`
+	Webhooks          []*Webhook `json:"webhooks"`
+	// ClientAuth requires WebDriver clients to authenticate.
+	ClientAuth *ClientAuth `json:"clientAuth"`
+	// Registration requires nodes to prove they are allowed to register.
+	Registration *Registration `json:"registration"`
+	// NoVncUrl is an ES module of the noVNC client which the session viewer at /grid/vnc/{session}/view loads.
+	// The viewer is disabled without it, so the hub does not make browsers load third-party scripts.
+	NoVncUrl string `json:"noVncUrl"`
+	// BasePath is an additional prefix of all hub endpoints, e.g. when the hub runs behind a reverse proxy.
+	// WebDriver API is always served at /wd/hub and at the root.
+	BasePath string `json:"basePath"`
+	// AdminToken is a bearer token required by /grid/api management endpoints and /debug/vars.
+	AdminToken string `json:"adminToken"`
+	// TLS enables HTTPS on the hub.
+	TLS *TLS `json:"tls"`
+	// NodeTLS configures connections to https:// nodes.
+	NodeTLS *NodeTLS `json:"nodeTls"`
+	// Tenants limits sessions of teams. Tenant "default" limits teams which are not listed.
+	Tenants map[string]*Tenant `json:"tenants"`
+	// PriorityAging raises priority of a waiting request by one for every period.
+	PriorityAging Duration `json:"priorityAging"`
+	// NewSessionAttempts is how many nodes are tried when a node fails to create a session.
+	NewSessionAttempts int `json:"newSessionAttempts"`
+	// NodePenalty is how long a node which failed NodePenaltyThreshold new sessions in a row is used last.
+	NodePenalty          Duration `json:"nodePenalty"`
+	NodePenaltyThreshold int      `json:"nodePenaltyThreshold"`
+	// CircuitBreaker removes nodes with a high error rate from scheduling. It is disabled when empty.
+	CircuitBreaker *CircuitBreaker `json:"circuitBreaker"`
+	// Placement orders equally matching slots: least-busy, round-robin, random or bin-packing.
+	Placement string `json:"placement"`
+	// Nodes which never call /grid/register. They use the same format as a registration request.
+	RawNodes []json.RawMessage   `json:"nodes"`
+	Nodes    []*translator.Proxy `json:"-"`
+	Docker   *Docker             `json:"docker"`
+	Exec     []*Exec             `json:"exec"`
+	Drivers  []*Driver           `json:"drivers"`
+}
+
+// Log configures structured logging.
+type Log struct {
+	// Format is "logfmt" or "json".
+	Format string `json:"format"`
+	// Level is debug, info, warn or error.
+	Level string `json:"level"`
+	// Subsystems overrides level for subsystems: hub, proxy, provisioner, driver, http.
+	Subsystems map[string]string `json:"subsystems"`
+}
+
+// Recorder configures the command audit log.
+type Recorder struct {
+	// MaxBodySize is how many bytes of request and response bodies are kept. Zero means no limit.
+	MaxBodySize int `json:"maxBodySize"`
+	// Retention is how long commands are kept after a session is finished.
+	Retention Duration `json:"retention"`
+}
+
+// Screenshots configures where final screenshots of sessions are stored.
+type Screenshots struct {
+	Directory string   `json:"directory"`
+	// Timeout limits a screenshot request, so a hung browser does not keep its session from being freed.
+	Timeout   Duration `json:"timeout"`
+}
+
+// Video configures a recorder sidecar. Substring {host} in Url is replaced by the host of a node.
+// The sidecar accepts POST {url}/start and POST {url}/stop with {"sessionId": "..."}
+// and answers {"url": "..."} with a link to the recorded video on stop.
+type Video struct {
+	Url     string   `json:"url"`
+	Timeout Duration `json:"timeout"`
+}
+
+// ClientAuth configures authentication of WebDriver clients on /wd/hub.
+type ClientAuth struct {
+	// CredentialsFile has "user:password" lines for HTTP basic authentication.
+	CredentialsFile string `json:"credentialsFile"`
+	// Tokens maps bearer API tokens to identities.
+	Tokens map[string]string `json:"tokens"`
+}
+
+// Registration configures authentication of nodes on /grid/register.
+type Registration struct {
+	// Secret is sent by a node as "registrationSecret" in its configuration or in X-Registration-Secret header.
+	Secret string `json:"secret"`
+	// ClientCertificate accepts nodes which present a client certificate trusted by the hub.
+	ClientCertificate bool `json:"clientCertificate"`
+}
+
+// TLS configures HTTPS serving. Certificate and key are reloaded when the files change.
+type TLS struct {
+	Certificate string `json:"certificate"`
+	Key         string `json:"key"`
+	// ClientCA verifies client certificates, e.g. of nodes which register with mTLS.
+	ClientCA       string   `json:"clientCA"`
+	ReloadInterval Duration `json:"reloadInterval"`
+}
+
+// NodeTLS configures TLS connections to nodes.
+type NodeTLS struct {
+	// CA is a PEM bundle of authorities which sign node certificates. System roots are used when empty.
+	CA string `json:"ca"`
+	// Certificate and Key are an optional client certificate presented to nodes.
+	Certificate        string `json:"certificate"`
+	Key                string `json:"key"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+}
+
+// Tenant limits concurrent sessions and queued requests of a team. Zero means no limit.
+type Tenant struct {
+	MaxSessions int `json:"maxSessions"`
+	MaxQueued   int `json:"maxQueued"`
+	// Priority of requests which do not have hub:priority capability.
+	Priority int `json:"priority"`
+	// Members are client identities which belong to the tenant. When the list is not empty,
+	// only members may choose the tenant by hub:team capability.
+	Members []string `json:"members"`
+}
+
+// CircuitBreaker opens when Threshold share of the last Window requests to a node failed. Failed requests are
+// new sessions which the node did not create and proxied commands which got a network error or 502-504 status.
+type CircuitBreaker struct {
+	Window      int     `json:"window"`
+	Threshold   float64 `json:"threshold"`
+	MinRequests int     `json:"minRequests"`
+	// CoolDown is how long an open circuit keeps the node out of scheduling before a probe session.
+	CoolDown Duration `json:"coolDown"`
+}
+
+// Webhook receives grid events as signed JSON POST requests.
+type Webhook struct {
+	Url string `json:"url"`
+	// Secret signs a body with HMAC-SHA256. The signature is sent in X-Hub-Signature-256.
+	Secret string `json:"secret"`
+	// Events to deliver. Empty list means all events.
+	Events  []string `json:"events"`
+	Retries int      `json:"retries"`
+	Timeout Duration `json:"timeout"`
+}
+
+// Docker describes browser images which are started on demand through the Docker Engine API.
+type Docker struct {
+	// Endpoint is unix:///var/run/docker.sock or tcp://host:2375.
+	Endpoint string `json:"endpoint"`
+	// Host is used to reach published ports of containers. By default it is a host of the endpoint.
+	Host         string         `json:"host"`
+	StartTimeout Duration       `json:"startTimeout"`
+	Images       []*DockerImage `json:"images"`
+	// MaxNodes limits containers which are running at the same time. Zero means no limit.
+	MaxNodes int `json:"maxNodes"`
+}
+
+type DockerImage struct {
+	session.Capabilities
+	Image string `json:"image"`
+	// Port where WebDriver listens inside the container.
+	Port uint16 `json:"port"`
+	// BasePath is where the container serves WebDriver API: /wd/hub when empty, / for the root.
+	BasePath string `json:"basePath"`
+	// Labels of nodes started from the image. The image is used only for requests whose hub:nodeSelector they satisfy.
+	Labels map[string]string `json:"labels"`
+}
+
+// Exec describes a local command which starts a WebDriver server for one session.
+// Substring {port} in arguments is replaced by a free port.
+type Exec struct {
+	session.Capabilities
+	Command      string   `json:"command"`
+	Args         []string `json:"args"`
+	StartTimeout Duration `json:"startTimeout"`
+	// MaxNodes limits processes which are running at the same time. Zero means no limit.
+	MaxNodes int `json:"maxNodes"`
+	// BasePath is where the command serves WebDriver API: /wd/hub when empty, / for the root.
+	BasePath string `json:"basePath"`
+	// Labels of nodes started by the command. It is used only for requests whose hub:nodeSelector they satisfy.
+	Labels map[string]string `json:"labels"`
+}
+
+// Driver is a local chromedriver or geckodriver binary which the hub keeps running as a node.
+// Substring {port} in arguments is replaced by a free port.
+type Driver struct {
+	session.Capabilities
+	Path         string   `json:"path"`
+	Args         []string `json:"args"`
+	MaxInstances uint8    `json:"maxInstances"`
+	StartTimeout Duration `json:"startTimeout"`
+	// BasePath is where the driver serves WebDriver API: /wd/hub when empty, / for the root.
+	BasePath string `json:"basePath"`
+}
+
+func New() *Config {
+	var config *Config = new(Config)
+	config.Address = ":4444"
+	config.SessionTimeout.Duration = 30 * time.Second
+	config.NodeTimeout.Duration = 30 * time.Second
+	config.HealthCheckInterval.Duration = 10 * time.Second
+	config.ShutdownTimeout.Duration = time.Minute
+	config.ArtifactRetention.Duration = 24 * time.Hour
+	config.PriorityAging.Duration = time.Minute
+	config.Placement = "least-busy"
+	config.MaxBodySize = 64 << 20
+	config.NewSessionAttempts = 3
+	config.NodePenalty.Duration = 5 * time.Minute
+	config.NodePenaltyThreshold = 3
+	config.Log.Format = "logfmt"
+	config.Log.Level = "info"
+	return config
+}
+
+// Load reads configuration from the file. Empty path means default configuration.
+func Load(path string) (*Config, error) {
+	config := New()
+	if path == "" {
+		return config, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	for _, raw := range config.RawNodes {
+		machine, err := translator.GetProxy(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		for _, capabilities := range machine.Capabilities {
+			if capabilities.SeleniumProtocol == "" {
+				capabilities.SeleniumProtocol = "WebDriver"
+			}
+		}
+		config.Nodes = append(config.Nodes, machine)
+	}
+	if config.Docker != nil {
+		setDockerDefaults(config.Docker)
+	}
+	if config.Recorder != nil && config.Recorder.Retention.Duration == 0 {
+		config.Recorder.Retention.Duration = time.Hour
+	}
+	if config.Screenshots != nil && config.Screenshots.Directory == "" {
+		config.Screenshots.Directory = "artifacts"
+	}
+	if config.Screenshots != nil && config.Screenshots.Timeout.Duration == 0 {
+		config.Screenshots.Timeout.Duration = 30 * time.Second
+	}
+	if config.Video != nil && config.Video.Timeout.Duration == 0 {
+		config.Video.Timeout.Duration = 30 * time.Second
+	}
+	if config.CircuitBreaker != nil {
+		setCircuitBreakerDefaults(config.CircuitBreaker)
+	}
+	if config.TLS != nil && config.TLS.ReloadInterval.Duration == 0 {
+		config.TLS.ReloadInterval.Duration = time.Minute
+	}
+	for _, webhook := range config.Webhooks {
+		if webhook.Retries == 0 {
+			webhook.Retries = 3
+		}
+		if webhook.Timeout.Duration == 0 {
+			webhook.Timeout.Duration = 10 * time.Second
+		}
+	}
+	for _, driver := range config.Drivers {
+		setDriverDefaults(driver)
+	}
+	for _, command := range config.Exec {
+		if command.StartTimeout.Duration == 0 {
+			command.StartTimeout.Duration = 30 * time.Second
+		}
+	}
+	return config, nil
+}
+
+func setDockerDefaults(docker *Docker) {
+	if docker.Endpoint == "" {
+		docker.Endpoint = "unix:///var/run/docker.sock"
+	}
+	if docker.StartTimeout.Duration == 0 {
+		docker.StartTimeout.Duration = time.Minute
+	}
+	for _, image := range docker.Images {
+		if image.Port == 0 {
+			image.Port = 4444
+		}
+	}
+}
+
+func setCircuitBreakerDefaults(breaker *CircuitBreaker) {
+	if breaker.Window == 0 {
+		breaker.Window = 20
+	}
+	if breaker.Threshold == 0 {
+		breaker.Threshold = 0.5
+	}
+	if breaker.MinRequests == 0 {
+		breaker.MinRequests = 5
+	}
+	if breaker.CoolDown.Duration == 0 {
+		breaker.CoolDown.Duration = time.Minute
+	}
+}
+
+func setDriverDefaults(driver *Driver) {
+	switch filepath.Base(driver.Path) {
+	case "chromedriver", "chromedriver.exe":
+		if driver.BrowserName == "" {
+			driver.BrowserName = "chrome"
+		}
+		if driver.Args == nil {
+			driver.Args = []string{"--port={port}", "--url-base=/wd/hub"}
+		}
+	case "geckodriver", "geckodriver.exe":
+		if driver.BrowserName == "" {
+			driver.BrowserName = "firefox"
+		}
+		if driver.Args == nil {
+			driver.Args = []string{"--port", "{port}"}
+		}
+		// geckodriver serves WebDriver API at the root.
+		if driver.BasePath == "" {
+			driver.BasePath = "/"
+		}
+		// geckodriver handles only one session at a time.
+		driver.MaxInstances = 1
+	}
+	if driver.MaxInstances == 0 {
+		driver.MaxInstances = 1
+	}
+	if driver.StartTimeout.Duration == 0 {
+		driver.StartTimeout.Duration = 30 * time.Second
+	}
+}
@@ -0,0 +1,127 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"selenium-hub/config"
+	"selenium-hub/hub"
+	"selenium-hub/logger"
+	"selenium-hub/provisioner"
+	"selenium-hub/translator"
+)
+
+var log = logger.For("driver")
+
+// restartDelay is a pause before a crashed driver is started again.
+const restartDelay = time.Second
+
+// Manager keeps local WebDriver binaries running and registered in the hub as nodes.
+type Manager struct {
+	seleniumHub *hub.Hub
+	processes   map[*config.Driver]*exec.Cmd
+	locker      *sync.Mutex
+	stopped     bool
+	done        *sync.WaitGroup
+}
+
+// Start launches every configured driver and watches it until Stop is called.
+func Start(seleniumHub *hub.Hub, drivers []*config.Driver) *Manager {
+	var manager *Manager = new(Manager)
+	manager.seleniumHub = seleniumHub
+	manager.processes = make(map[*config.Driver]*exec.Cmd)
+	manager.locker = new(sync.Mutex)
+	manager.done = new(sync.WaitGroup)
+	for _, driver := range drivers {
+		manager.done.Add(1)
+		go manager.supervise(driver)
+	}
+	return manager
+}
+
+// Stop kills all drivers and waits until their supervisors exit.
+func (manager *Manager) Stop() {
+	manager.locker.Lock()
+	manager.stopped = true
+	for _, process := range manager.processes {
+		process.Process.Kill()
+	}
+	manager.locker.Unlock()
+	manager.done.Wait()
+}
+
+func (manager *Manager) supervise(driver *config.Driver) {
+	defer manager.done.Done()
+	for !manager.isStopped() {
+		process, machine, err := manager.start(driver)
+		if err != nil {
+			log.Error("Could not start driver", "driver", driver.Path, "error", err)
+			time.Sleep(restartDelay)
+			continue
+		}
+		nodeUrl := machine.Configuration.Url
+		manager.seleniumHub.RegisterStaticNode(machine)
+		err = process.Wait()
+		manager.seleniumHub.DeleteNode(context.Background(), nodeUrl)
+		manager.locker.Lock()
+		delete(manager.processes, driver)
+		manager.locker.Unlock()
+		if !manager.isStopped() {
+			log.Warn("Driver exited, restart it", "driver", driver.Path, "node", nodeUrl, "error", err)
+			time.Sleep(restartDelay)
+		}
+	}
+}
+
+func (manager *Manager) start(driver *config.Driver) (*exec.Cmd, *translator.Proxy, error) {
+	port, err := provisioner.FreePort()
+	if err != nil {
+		return nil, nil, err
+	}
+	var args []string
+	for _, arg := range driver.Args {
+		args = append(args, strings.Replace(arg, "{port}", strconv.Itoa(port), -1))
+	}
+	process := exec.Command(driver.Path, args...)
+	process.Stdout = os.Stdout
+	process.Stderr = os.Stderr
+	process.SysProcAttr = sysProcAttr()
+	manager.locker.Lock()
+	if manager.stopped {
+		manager.locker.Unlock()
+		return nil, nil, fmt.Errorf("driver manager is stopped")
+	}
+	err = process.Start()
+	if err == nil {
+		manager.processes[driver] = process
+	}
+	manager.locker.Unlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	nodeUrl := fmt.Sprintf("http://127.0.0.1:%d", port)
+	machine := translator.NewProxy(nodeUrl, driver.Capabilities, driver.MaxInstances)
+	machine.Configuration.BasePath = driver.BasePath
+	if !provisioner.WaitReady(machine.Endpoint(), driver.StartTimeout.Duration) {
+		process.Process.Kill()
+		process.Wait()
+		manager.locker.Lock()
+		delete(manager.processes, driver)
+		manager.locker.Unlock()
+		return nil, nil, fmt.Errorf("%s was not ready in %s", driver.Path, driver.StartTimeout)
+	}
+	log.Info("Driver started", "driver", driver.Path, "node", nodeUrl)
+	return process, machine, nil
+}
+
+func (manager *Manager) isStopped() bool {
+	manager.locker.Lock()
+	defer manager.locker.Unlock()
+	return manager.stopped
+}
@@ -0,0 +1,10 @@
+package driver
+
+import (
+	"syscall"
+)
+
+// sysProcAttr makes the kernel kill a driver when the hub process dies without Stop.
+func sysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+}
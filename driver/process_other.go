@@ -0,0 +1,11 @@
+//go:build !linux
+
+package driver
+
+import (
+	"syscall"
+)
+
+func sysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"selenium-hub/hub"
+)
+
+// eventsBuffer is how many events may wait for a slow client before they are dropped.
+const eventsBuffer = 256
+
+// keepAlive is how often a comment is sent to keep an idle stream open through proxies.
+const keepAlive = 15 * time.Second
+
+// httpEvents streams hub events as Server-Sent Events. Optional "browser" and "node"
+// query parameters filter events by browser name and node URL.
+func httpEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+	browser := r.FormValue("browser")
+	node := r.FormValue("node")
+	events := make(chan hub.Event, eventsBuffer)
+	unsubscribe := seleniumHub.Subscribe(func(event hub.Event) {
+		if browser != "" && (event.Capabilities == nil || event.Capabilities.BrowserName != browser) {
+			return
+		}
+		if node != "" && event.Node != node {
+			return
+		}
+		select {
+		case events <- event:
+		default:
+			log.WarnContext(r.Context(), "Event stream is too slow, event is dropped", "event", event.Type)
+		}
+	})
+	defer unsubscribe()
+	// The stream lives longer than the server write timeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Server", "go-selenium-hub")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	var eventId uint64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-events:
+			eventId++
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", eventId, event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,62 @@
+package hub
+
+import (
+	"time"
+
+	"selenium-hub/session"
+)
+
+const (
+	EventNodeRegistered  = "node.registered"
+	EventNodeRemoved     = "node.removed"
+	EventNodeUnhealthy   = "node.unhealthy"
+	EventCircuitOpened   = "node.circuitOpened"
+	EventCircuitClosed   = "node.circuitClosed"
+	EventSessionReserved = "session.reserved"
+	EventSessionCreated  = "session.created"
+	EventSessionFreed    = "session.freed"
+	EventSessionTimeout  = "session.timedOut"
+	EventSessionKilled   = "session.killed"
+	EventQueueTimeout    = "queue.timeout"
+)
+
+// Event is a change of the grid state.
+type Event struct {
+	Type         string                `json:"type"`
+	Time         time.Time             `json:"time"`
+	SessionId    string                `json:"sessionId,omitempty"`
+	Node         string                `json:"node,omitempty"`
+	Capabilities *session.Capabilities `json:"capabilities,omitempty"`
+	Message      string                `json:"message,omitempty"`
+}
+
+// EventListener receives every event of the hub. It is called synchronously and must not block.
+type EventListener func(event Event)
+
+// Subscribe adds the listener and returns a function which removes it.
+func (seleniumHub *Hub) Subscribe(listener EventListener) func() {
+	seleniumHub.listenersLocker.Lock()
+	defer seleniumHub.listenersLocker.Unlock()
+	seleniumHub.lastListenerId++
+	listenerId := seleniumHub.lastListenerId
+	seleniumHub.listeners[listenerId] = listener
+	return func() {
+		seleniumHub.listenersLocker.Lock()
+		defer seleniumHub.listenersLocker.Unlock()
+		delete(seleniumHub.listeners, listenerId)
+	}
+}
+
+func (seleniumHub *Hub) emit(event Event) {
+	event.Time = time.Now()
+	seleniumHub.listenersLocker.RLock()
+	defer seleniumHub.listenersLocker.RUnlock()
+	for _, listener := range seleniumHub.listeners {
+		listener(event)
+	}
+}
+
+func (seleniumHub *Hub) emitSession(eventType string, info SessionInfo) {
+	capabilities := info.Capabilities
+	seleniumHub.emit(Event{Type: eventType, SessionId: info.Id, Node: info.Node.Url, Capabilities: &capabilities})
+}
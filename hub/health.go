@@ -0,0 +1,39 @@
+package hub
+
+import (
+	"context"
+	"selenium-hub/proxy"
+	"selenium-hub/translator"
+	"time"
+)
+
+// watchStaticNode keeps a static node registered while it answers to the status request.
+func (seleniumHub *Hub) watchStaticNode(machine *translator.Proxy) {
+	nodeId := machine.Configuration.Url
+	ticker := time.NewTicker(seleniumHub.healthCheck)
+	defer ticker.Stop()
+	for {
+		healthy := proxy.Ping(machine.Endpoint())
+		registered := seleniumHub.hasNode(nodeId)
+		if healthy && !registered {
+			log.Info("Static node is healthy, register it", "node", nodeId)
+			seleniumHub.RegisterStaticNode(machine)
+		} else if !healthy && registered {
+			log.Warn("Static node does not answer, delete it", "node", nodeId)
+			seleniumHub.emit(Event{Type: EventNodeUnhealthy, Node: nodeId, Message: "health check failed"})
+			seleniumHub.DeleteNode(context.Background(), nodeId)
+		}
+		select {
+		case <-ticker.C:
+		case <-seleniumHub.draining:
+			return
+		}
+	}
+}
+
+func (seleniumHub *Hub) hasNode(nodeId string) bool {
+	seleniumHub.nodesLocker.RLock()
+	defer seleniumHub.nodesLocker.RUnlock()
+	_, found := seleniumHub.nodes[nodeId]
+	return found
+}
@@ -0,0 +1,121 @@
+package hub
+
+import (
+	"time"
+
+	"selenium-hub/artifact"
+	"selenium-hub/session"
+)
+
+// SessionInfo describes a session passed to hooks. It is a snapshot, so hooks may keep it.
+type SessionInfo struct {
+	Id           string
+	Node         *session.Node
+	Capabilities session.Capabilities
+	Owner        string
+}
+
+func newSessionInfo(seleniumSession *session.Session) SessionInfo {
+	return SessionInfo{seleniumSession.Id, seleniumSession.Node, *seleniumSession.Capabilities, seleniumSession.Owner}
+}
+
+// Hook is notified about session lifecycle. Methods are called synchronously, so they must be fast
+// or limit their own work by timeouts.
+type Hook interface {
+	// OnSessionStart is called after a session was created on a node.
+	OnSessionStart(info SessionInfo)
+	// OnSessionEnd is called when a session is freed, timed out or killed with its node,
+	// before its slot is released.
+	OnSessionEnd(info SessionInfo)
+}
+
+func (seleniumHub *Hub) AddHook(hook Hook) {
+	seleniumHub.hooksLocker.Lock()
+	defer seleniumHub.hooksLocker.Unlock()
+	seleniumHub.hooks = append(seleniumHub.hooks, hook)
+}
+
+func (seleniumHub *Hub) sessionStarted(info SessionInfo) {
+	seleniumHub.hooksLocker.RLock()
+	hooks := seleniumHub.hooks
+	seleniumHub.hooksLocker.RUnlock()
+	for _, hook := range hooks {
+		hook.OnSessionStart(info)
+	}
+}
+
+func (seleniumHub *Hub) sessionEnded(info SessionInfo) {
+	seleniumHub.hooksLocker.RLock()
+	hooks := seleniumHub.hooks
+	seleniumHub.hooksLocker.RUnlock()
+	for _, hook := range hooks {
+		hook.OnSessionEnd(info)
+	}
+}
+
+type sessionArtifacts struct {
+	links   map[string]string
+	updated time.Time
+}
+
+// SetArtifact stores a link to an artifact of the session, e.g. a video.
+func (seleniumHub *Hub) SetArtifact(sessionId string, name string, link string) {
+	seleniumHub.artifactsLocker.Lock()
+	defer seleniumHub.artifactsLocker.Unlock()
+	now := time.Now()
+	for id, artifacts := range seleniumHub.artifacts {
+		if now.Sub(artifacts.updated) > seleniumHub.artifactRetention {
+			delete(seleniumHub.artifacts, id)
+		}
+	}
+	artifacts, found := seleniumHub.artifacts[sessionId]
+	if !found {
+		artifacts = &sessionArtifacts{links: make(map[string]string)}
+		seleniumHub.artifacts[sessionId] = artifacts
+	}
+	artifacts.links[name] = link
+	artifacts.updated = now
+}
+
+// GetArtifacts returns links to artifacts of the session.
+func (seleniumHub *Hub) GetArtifacts(sessionId string) (map[string]string, bool) {
+	seleniumHub.artifactsLocker.RLock()
+	defer seleniumHub.artifactsLocker.RUnlock()
+	artifacts, found := seleniumHub.artifacts[sessionId]
+	if !found {
+		return nil, false
+	}
+	links := make(map[string]string)
+	for name, link := range artifacts.links {
+		links[name] = link
+	}
+	return links, true
+}
+
+// videoHook records every session with a sidecar on the node host.
+type videoHook struct {
+	seleniumHub *Hub
+	video       *artifact.Video
+}
+
+func newVideoHook(seleniumHub *Hub, video *artifact.Video) *videoHook {
+	return &videoHook{seleniumHub, video}
+}
+
+func (hook *videoHook) OnSessionStart(info SessionInfo) {
+	if err := hook.video.Start(info.Node.Url, info.Id); err != nil {
+		log.Warn("Could not start video recording", "session", info.Id, "node", info.Node.Url, "error", err)
+	}
+}
+
+func (hook *videoHook) OnSessionEnd(info SessionInfo) {
+	link, err := hook.video.Stop(info.Node.Url, info.Id)
+	if err != nil {
+		log.Warn("Could not stop video recording", "session", info.Id, "node", info.Node.Url, "error", err)
+		return
+	}
+	if link != "" {
+		log.Info("Video recorded", "session", info.Id, "node", info.Node.Url, "video", link)
+		hook.seleniumHub.SetArtifact(info.Id, "video", link)
+	}
+}
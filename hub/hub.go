@@ -8,14 +8,26 @@ import (
 	"sync"
 	"sort"
 	"time"
-	"log"
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	neturl "net/url"
+	"net"
 	"net/http"
 	"selenium-hub/session"
 	"selenium-hub/proxy"
 	"selenium-hub/translator"
+	"selenium-hub/config"
+	"selenium-hub/provisioner"
+	"selenium-hub/logger"
+	"selenium-hub/artifact"
+	"selenium-hub/auth"
 )
 
+var log = logger.For("hub")
+
 type Hub struct {
 	nodes              map[string]*session.Node
 	activeSessions     map[string]*session.Session
@@ -25,102 +37,426 @@ type Hub struct {
 	availableLocker    *sync.RWMutex
 	sessionTimeout     time.Duration
 	nodeTimeout        time.Duration
+	healthCheck        time.Duration
+	provisioners       []Provisioner
+	provisioned        map[string]Provisioner
+	provisionersLocker *sync.RWMutex
+	// draining is closed when the hub stops accepting new sessions.
+	draining           chan struct{}
+	drainOnce          *sync.Once
+	screenshots        *artifact.Screenshots
+	hooks              []Hook
+	hooksLocker        *sync.RWMutex
+	artifacts          map[string]*sessionArtifacts
+	artifactsLocker    *sync.RWMutex
+	artifactRetention  time.Duration
+	listeners          map[uint64]EventListener
+	lastListenerId     uint64
+	listenersLocker    *sync.RWMutex
+	queueTimeout       time.Duration
+	quotas             *quotas
+	queue              map[*session.QueueElement]*QueuedRequest
+	queueLocker        *sync.RWMutex
+	priorityAging      time.Duration
+	placement          session.Strategy
+	penalty            time.Duration
+	penaltyThreshold   int
+	breaker            *config.CircuitBreaker
 }
 
-func New() *Hub {
+const (
+	registeredNode uint8 = iota
+	staticNode
+	provisionedNode
+)
+
+func New(configuration *config.Config) (*Hub, error) {
 	var hub *Hub = new(Hub)
 	hub.nodes = make(map[string]*session.Node)
 	hub.activeSessions = make(map[string]*session.Session)
 	hub.nodesLocker = new(sync.RWMutex)
 	hub.activeLocker = new(sync.RWMutex)
 	hub.availableLocker = new(sync.RWMutex)
-	hub.sessionTimeout = 30*time.Second
-	hub.nodeTimeout = 30*time.Second
-	return hub
+	hub.sessionTimeout = configuration.SessionTimeout.Duration
+	hub.nodeTimeout = configuration.NodeTimeout.Duration
+	hub.healthCheck = configuration.HealthCheckInterval.Duration
+	hub.provisioned = make(map[string]Provisioner)
+	hub.provisionersLocker = new(sync.RWMutex)
+	hub.draining = make(chan struct{})
+	hub.drainOnce = new(sync.Once)
+	hub.hooksLocker = new(sync.RWMutex)
+	hub.listeners = make(map[uint64]EventListener)
+	hub.listenersLocker = new(sync.RWMutex)
+	hub.queueTimeout = configuration.NewSessionWaitTimeout.Duration
+	hub.quotas = newQuotas(configuration.Tenants, hub.draining)
+	hub.queue = make(map[*session.QueueElement]*QueuedRequest)
+	hub.queueLocker = new(sync.RWMutex)
+	hub.priorityAging = configuration.PriorityAging.Duration
+	placement, err := session.NewStrategy(configuration.Placement)
+	if err != nil {
+		return nil, err
+	}
+	hub.placement = placement
+	hub.penalty = configuration.NodePenalty.Duration
+	hub.penaltyThreshold = configuration.NodePenaltyThreshold
+	hub.breaker = configuration.CircuitBreaker
+	hub.artifacts = make(map[string]*sessionArtifacts)
+	hub.artifactsLocker = new(sync.RWMutex)
+	hub.artifactRetention = configuration.ArtifactRetention.Duration
+	if configuration.Docker != nil {
+		docker, err := provisioner.NewDocker(configuration.Docker)
+		if err != nil {
+			return nil, err
+		}
+		hub.AddProvisioner(docker)
+	}
+	for _, command := range configuration.Exec {
+		hub.AddProvisioner(provisioner.NewExec(command))
+	}
+	if configuration.Screenshots != nil {
+		screenshots, err := artifact.NewScreenshots(configuration.Screenshots.Directory,
+			configuration.Screenshots.Timeout.Duration)
+		if err != nil {
+			return nil, err
+		}
+		hub.screenshots = screenshots
+	}
+	if configuration.Video != nil {
+		hub.AddHook(newVideoHook(hub, artifact.NewVideo(configuration.Video.Url, configuration.Video.Timeout.Duration)))
+	}
+	for _, machine := range configuration.Nodes {
+		go hub.watchStaticNode(machine)
+	}
+	return hub, nil
+}
+
+var (
+	ErrDraining      = errors.New("hub is shutting down and does not accept new sessions")
+	ErrNoSlot        = errors.New("session for required capabilities was not found")
+	ErrQueueTimeout  = errors.New("no slot was freed in time")
+	ErrQuotaExceeded = errors.New("tenant has too many queued requests")
+	ErrNodeUnknown   = errors.New("requested node is not registered")
+	ErrNodeDraining  = errors.New("requested node is draining")
+	ErrNodeSingleUse = errors.New("requested node serves only the session it was provisioned for")
+	// errProbeTaken makes a request, which got a slot of a node being probed, wait for a slot again.
+	errProbeTaken    = errors.New("node is probed by another session")
+)
+
+// ReserveSession waits for a slot which satisfies capabilities. The reservation counts in the quota
+// of the client tenant until the session is freed or cancelled. Slots of excluded nodes are not used.
+func (seleniumHub *Hub) ReserveSession(ctx context.Context, capabilities *session.Capabilities,
+	excluded ...*session.Node) (*session.Session, error) {
+	if seleniumHub.IsDraining() {
+		log.WarnContext(ctx, "Hub is draining, session is not reserved", "capabilities", capabilities)
+		return nil, ErrDraining
+	}
+	var timeout <-chan time.Time
+	if seleniumHub.queueTimeout > 0 {
+		timer := time.NewTimer(seleniumHub.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	tenant := seleniumHub.quotas.tenant(auth.Identity(ctx), capabilities.Team)
+	if err := seleniumHub.quotas.acquire(ctx, tenant, timeout); err != nil {
+		log.WarnContext(ctx, "Tenant quota is exhausted", "tenant", tenant, "capabilities", capabilities, "error", err)
+		if err == ErrQueueTimeout {
+			seleniumHub.emit(Event{Type: EventQueueTimeout, Capabilities: capabilities, Message: "tenant " + tenant})
+		}
+		return nil, err
+	}
+	seleniumSession, err := seleniumHub.reserveSlot(ctx, capabilities, tenant, timeout, excluded)
+	for err == errProbeTaken {
+		seleniumSession, err = seleniumHub.reserveSlot(ctx, capabilities, tenant, timeout, excluded)
+	}
+	if err != nil {
+		seleniumHub.quotas.release(tenant)
+		return nil, err
+	}
+	seleniumSession.Tenant = tenant
+	return seleniumSession, nil
 }
 
-func (seleniumHub *Hub) ReserveSession(capabilities *session.Capabilities) (*session.Session, bool) {
-	cs := seleniumHub.getSortedSessions(*capabilities)
+func (seleniumHub *Hub) reserveSlot(ctx context.Context, capabilities *session.Capabilities, tenant string,
+	timeout <-chan time.Time, excluded []*session.Node) (*session.Session, error) {
+	var pinned *session.Node
+	if capabilities.Node != "" {
+		seleniumNode, found := seleniumHub.findNode(capabilities.Node)
+		if !found {
+			log.WarnContext(ctx, "Requested node is not registered", "node", capabilities.Node, "capabilities", capabilities)
+			return nil, fmt.Errorf("%w: %s", ErrNodeUnknown, capabilities.Node)
+		}
+		if seleniumNode.SingleUse {
+			log.WarnContext(ctx, "Requested node is single-use", "node", seleniumNode.Url, "capabilities", capabilities)
+			return nil, fmt.Errorf("%w: %s", ErrNodeSingleUse, seleniumNode.Url)
+		}
+		if seleniumNode.Draining.Load() {
+			log.WarnContext(ctx, "Requested node is draining", "node", seleniumNode.Url, "capabilities", capabilities)
+			return nil, fmt.Errorf("%w: %s", ErrNodeDraining, seleniumNode.Url)
+		}
+		pinned = seleniumNode
+	}
+	cs := seleniumHub.getSortedSessions(*capabilities, pinned, excluded)
+	if cs.Len() == 0 && pinned == nil {
+		// A provisioned node serves only the reservation it was started for.
+		provisioned, err := seleniumHub.awaitProvision(ctx, capabilities, timeout)
+		if err != nil {
+			return nil, err
+		}
+		if provisioned != nil {
+			return seleniumHub.reserveProvisioned(ctx, capabilities, tenant, timeout, provisioned)
+		}
+	}
 	if cs.Len() == 0 {
-		return nil, false
+		log.InfoContext(ctx, "No slot matches capabilities", "capabilities", capabilities)
+		return nil, ErrNoSlot
 	}
+	return seleniumHub.queueForSlot(ctx, capabilities, tenant, timeout, cs)
+}
+
+// queueForSlot waits until one of the sorted slots is handed to the request.
+func (seleniumHub *Hub) queueForSlot(ctx context.Context, capabilities *session.Capabilities, tenant string,
+	timeout <-chan time.Time, cs *session.CapabilitiesSorter) (*session.Session, error) {
 	sort.Sort(cs)
 	var controller *session.QueueElement = new(session.QueueElement)
 	controller.Actual = make(chan bool)
 	controller.Session = make(chan *session.Session)
+	controller.Priority = capabilities.Priority
+	if controller.Priority == 0 {
+		controller.Priority = seleniumHub.quotas.priority(tenant)
+	}
+	controller.Enqueued = time.Now()
+	controller.Aging = seleniumHub.priorityAging
+	seleniumHub.enqueue(controller, tenant, capabilities)
+	defer seleniumHub.dequeue(controller)
 	for _, sortedCapabilities := range cs.GetIterator() {
 		sortedCapabilities.Session.Register(controller)
-		log.Println(sortedCapabilities.Weight, sortedCapabilities.Session.Capabilities.BrowserName)
+		log.DebugContext(ctx, "Queued for slot", "node", sortedCapabilities.Session.Node.Url,
+			"capabilities", capabilities, "weight", sortedCapabilities.Weight)
+	}
+	// Slots which did not serve the request forget it, so it does not count in their weight.
+	defer func() {
+		for _, sortedCapabilities := range cs.GetIterator() {
+			sortedCapabilities.Session.Unregister(controller)
+		}
+	}()
+	select {
+	case controller.Actual <- true:
+	case <-timeout:
+		close(controller.Actual)
+		log.WarnContext(ctx, "No slot was freed in time", "capabilities", capabilities, "timeout", seleniumHub.queueTimeout)
+		seleniumHub.emit(Event{Type: EventQueueTimeout, Capabilities: capabilities})
+		return nil, ErrQueueTimeout
+	case <-seleniumHub.draining:
+		close(controller.Actual)
+		log.WarnContext(ctx, "Hub is draining, queued request is cancelled", "capabilities", capabilities)
+		return nil, ErrDraining
+	case <-ctx.Done():
+		close(controller.Actual)
+		log.InfoContext(ctx, "Client gave up waiting for slot", "capabilities", capabilities, "error", ctx.Err())
+		return nil, ctx.Err()
 	}
-	controller.Actual <- true
 	var session *session.Session = <-controller.Session
 	close(controller.Actual)
-	return session, true
+	if seleniumHub.IsDraining() {
+		// The slot was handed over while Shutdown was freeing sessions, so it is not used.
+		log.WarnContext(ctx, "Hub is draining, reserved slot is returned", "node", session.Node.Url,
+			"capabilities", capabilities)
+		session.Finish()
+		return nil, ErrDraining
+	}
+	if !session.Node.Breaker.Allow(session) {
+		// Another slot of the node became the probe of its half-open circuit while the request waited.
+		log.DebugContext(ctx, "Node is probed by another session, slot is returned", "node", session.Node.Url,
+			"capabilities", capabilities)
+		session.Finish()
+		return nil, errProbeTaken
+	}
+	session.Node.MarkUsed()
+	log.InfoContext(ctx, "Slot reserved", "node", session.Node.Url, "session", session.Id,
+		"capabilities", capabilities)
+	seleniumHub.emit(Event{Type: EventSessionReserved, Node: session.Node.Url, Capabilities: capabilities})
+	return session, nil
+}
+
+// CancelSession releases a reserved slot when the session could not be created on the node.
+// A node which fails too many sessions in a row is penalized.
+func (seleniumHub *Hub) CancelSession(ctx context.Context, seleniumSession *session.Session) {
+	if seleniumSession.Node.Failed(seleniumHub.penaltyThreshold, seleniumHub.penalty) {
+		log.WarnContext(ctx, "Node failed to create sessions and is penalized", "node", seleniumSession.Node.Url,
+			"failures", seleniumHub.penaltyThreshold, "penalty", seleniumHub.penalty)
+	}
+	seleniumHub.recordSessionResult(seleniumSession, true)
+	seleniumHub.ReleaseSession(ctx, seleniumSession)
+}
+
+// ReleaseSession releases a reserved slot when the node refused the session because of the client,
+// e.g. for invalid capabilities. The node is not penalized for it.
+func (seleniumHub *Hub) ReleaseSession(ctx context.Context, seleniumSession *session.Session) {
+	seleniumHub.quotas.release(seleniumSession.Tenant)
+	seleniumSession.Finish()
+	if seleniumSession.Node.SingleUse {
+		go seleniumHub.releaseNode(ctx, seleniumSession.Node.Url)
+	}
 }
 
 func (seleniumHub *Hub) StartSession(seleniumSession *session.Session) {
+	seleniumSession.Node.Succeeded()
+	seleniumHub.recordSessionResult(seleniumSession, false)
 	seleniumHub.activeLocker.Lock()
-	defer seleniumHub.activeLocker.Unlock()
+	sessionId := seleniumSession.Id
 	seleniumSession.Timer = time.AfterFunc(seleniumHub.sessionTimeout, func() {
-			seleniumHub.FreeSession(seleniumSession.Id)
+			log.Warn("Session timed out", "session", sessionId, "node", seleniumSession.Node.Url)
+			seleniumHub.emitSession(EventSessionTimeout, newSessionInfo(seleniumSession))
+			seleniumHub.CaptureScreenshot(context.Background(), sessionId)
+			seleniumHub.FreeSession(context.Background(), sessionId)
 		})
 	seleniumHub.activeSessions[seleniumSession.Id] = seleniumSession
+	seleniumHub.activeLocker.Unlock()
+	info := newSessionInfo(seleniumSession)
+	seleniumHub.emitSession(EventSessionCreated, info)
+	seleniumHub.sessionStarted(info)
 }
 
-func (seleniumHub *Hub) FreeSession(sessionId string) {
-	log.Println("Waiting lock for free session", sessionId)
+func (seleniumHub *Hub) FreeSession(ctx context.Context, sessionId string) {
+	log.DebugContext(ctx, "Waiting lock for free session", "session", sessionId)
 	seleniumHub.activeLocker.Lock()
-	defer seleniumHub.activeLocker.Unlock()
-	log.Println("Free session", sessionId)
-	seleniumSession := seleniumHub.activeSessions[sessionId]
-	seleniumSession.Finish()
+	seleniumSession, found := seleniumHub.activeSessions[sessionId]
+	if !found {
+		seleniumHub.activeLocker.Unlock()
+		log.DebugContext(ctx, "Session is not active", "session", sessionId)
+		return
+	}
+	log.InfoContext(ctx, "Free session", "session", sessionId, "node", seleniumSession.Node.Url)
 	delete(seleniumHub.activeSessions, sessionId)
+	seleniumHub.activeLocker.Unlock()
+	// Hooks are called before the slot is released, so the next session does not start on the node yet.
+	info := newSessionInfo(seleniumSession)
+	seleniumHub.sessionEnded(info)
+	seleniumHub.quotas.release(seleniumSession.Tenant)
+	seleniumSession.Finish()
+	seleniumHub.emitSession(EventSessionFreed, info)
+	if seleniumSession.Node.SingleUse {
+		go seleniumHub.releaseNode(ctx, seleniumSession.Node.Url)
+	}
 }
 
-func (seleniumHub *Hub) getSortedSessions(capabilities session.Capabilities) *session.CapabilitiesSorter {
+// getSortedSessions returns slots which match capabilities. When node is not nil, only its slots are used.
+// Slots of single-use nodes are used only when their node is requested, so nobody queues on them.
+func (seleniumHub *Hub) getSortedSessions(capabilities session.Capabilities, node *session.Node,
+	excluded []*session.Node) *session.CapabilitiesSorter {
 	seleniumHub.nodesLocker.RLock()
 	defer seleniumHub.nodesLocker.RUnlock()
-	cs := session.NewSorter(capabilities)
+	cs := session.NewSorter(capabilities, seleniumHub.placement)
 	for _, session := range seleniumHub.availableSessions {
+		if session.Node.Draining.Load() || (node != nil && session.Node != node) || (node == nil && session.Node.SingleUse) ||
+			isExcluded(session.Node, excluded) ||
+			!session.Node.Breaker.Available() {
+			continue
+		}
 		cs.Add(session)
 	}
 	return cs
 }
 
+func isExcluded(node *session.Node, excluded []*session.Node) bool {
+	for _, excludedNode := range excluded {
+		if node == excludedNode {
+			return true
+		}
+	}
+	return false
+}
+
+// findNode looks a node up by its id, which is its URL, or by host and port of the URL.
+func (seleniumHub *Hub) findNode(nodeId string) (*session.Node, bool) {
+	seleniumHub.nodesLocker.RLock()
+	defer seleniumHub.nodesLocker.RUnlock()
+	nodeId = strings.TrimRight(nodeId, "/")
+	for url, seleniumNode := range seleniumHub.nodes {
+		if strings.TrimRight(url, "/") == nodeId {
+			return seleniumNode, true
+		}
+	}
+	for url, seleniumNode := range seleniumHub.nodes {
+		if address, err := neturl.Parse(url); err == nil && address.Host == nodeId {
+			return seleniumNode, true
+		}
+	}
+	return nil, false
+}
+
+// DrainNode stops scheduling new sessions on the node. Active sessions keep working.
+func (seleniumHub *Hub) DrainNode(nodeId string) bool {
+	seleniumNode, found := seleniumHub.findNode(nodeId)
+	if found {
+		log.Info("Drain node", "node", seleniumNode.Url)
+		seleniumNode.Draining.Store(true)
+	}
+	return found
+}
+
 func (seleniumHub *Hub) prestartSession(seleniumSession *session.Session) {
-	log.Println("Prestart session", seleniumSession.Capabilities)
+	log.Info("Prestart session", "node", seleniumSession.Node.Url, "capabilities", seleniumSession.Capabilities)
 	data := translator.GetCreateSessionRequestData(seleniumSession.Capabilities)
-	log.Println("GetCreateSessionRequest", string(data))
 	var r *http.Request = new(http.Request)
 	r.Method = "POST"
 	r.RequestURI = "/wd/hub/session"
-	data, status, error := proxy.ProxyRequest(seleniumSession.Node.Url, r, bytes.NewReader(data))
-	log.Println(string(data))
+	data, status, error := proxy.ProxyRequest(seleniumSession.Node.Endpoint(), r, bytes.NewReader(data))
 	if error == nil && status == 200 {
 		answer := translator.GetCreateSessionAnswer(data)
 		if answer.Status == 0 {
-			seleniumSession.Status = session.Prestarted
+			seleniumSession.SetStatus(session.Prestarted)
 			seleniumSession.Id = answer.SessionID
 			seleniumSession.Capabilities = &answer.Value
+			log.Info("Session prestarted", "node", seleniumSession.Node.Url, "session", answer.SessionID,
+				"capabilities", seleniumSession.Capabilities)
 		}
 	} else {
-		log.Fatalln(error, status)
+		log.Error("Could not prestart session", "node", seleniumSession.Node.Url,
+			"capabilities", seleniumSession.Capabilities, "status", status, "error", error)
 	}
 }
 
-func (seleniumHub *Hub) RegisterNode(machine *translator.Proxy) (bool) {
+// DiscardPrestarted deletes the browser of a reserved prestarted session, so the slot can create a session
+// with capabilities which the prestarted browser does not have.
+func (seleniumHub *Hub) DiscardPrestarted(seleniumSession *session.Session) {
+	log.Info("Discard prestarted session", "node", seleniumSession.Node.Url, "session", seleniumSession.Id)
+	deleteRemoteSession(context.Background(), seleniumSession.Node.Endpoint(), seleniumSession.Id)
+	seleniumSession.Id = ""
+}
+
+func (seleniumHub *Hub) RegisterNode(ctx context.Context, machine *translator.Proxy) (bool) {
+	return seleniumHub.registerNode(ctx, machine, registeredNode)
+}
+
+// RegisterStaticNode registers a node from the hub configuration. Such node does not send heartbeats,
+// so it is not expired by nodeTimeout and is removed only when health check fails. No session is prestarted on it.
+func (seleniumHub *Hub) RegisterStaticNode(machine *translator.Proxy) (bool) {
+	return seleniumHub.registerNode(context.Background(), machine, staticNode)
+}
+
+func (seleniumHub *Hub) registerNode(ctx context.Context, machine *translator.Proxy, kind uint8) (bool) {
 	var sessions []*session.Session
 	seleniumNode := session.NewNode(machine.Configuration.Url, machine.Configuration.MaxSession)
+	seleniumNode.SingleUse = kind == provisionedNode
+	seleniumNode.Labels = machine.Configuration.Labels
+	seleniumNode.VncUrl = machine.Configuration.VncUrl
+	seleniumNode.BasePath = machine.BasePath()
+	seleniumNode.Breaker = seleniumHub.newBreaker(machine.Configuration.Url)
 	for _, capabilities := range machine.Capabilities {
 		if capabilities.SeleniumProtocol == "WebDriver" {
-			for ; capabilities.MaxInstances > 0; capabilities.MaxInstances-- {
+			for instances := capabilities.MaxInstances; instances > 0; instances-- {
 				sessions = append(sessions, session.New(capabilities.Capabilities, seleniumNode))
 			}
-			go seleniumHub.prestartSession(sessions[len(sessions) - 1])
+			// Only nodes which register themselves prestart a browser. Static nodes may be paid cloud endpoints
+			// and are registered again after every health check, and a provisioned node serves its own request.
+			if kind == registeredNode {
+				go seleniumHub.prestartSession(sessions[len(sessions) - 1])
+			}
 		}
 	}
 	if len(sessions) > 0 {
-		seleniumHub.DeleteNode(machine.Configuration.Url)
+		seleniumHub.DeleteNode(ctx, machine.Configuration.Url)
 		seleniumHub.nodesLocker.Lock()
 		defer seleniumHub.nodesLocker.Unlock()
 		seleniumHub.availableLocker.Lock()
@@ -130,59 +466,158 @@ func (seleniumHub *Hub) RegisterNode(machine *translator.Proxy) (bool) {
 		seleniumHub.nodes[machine.Configuration.Url] = seleniumNode
 		response := translator.GetApiProxyResponseData(machine)
 		seleniumNode.ApiProxyResponse = response
-		seleniumNode.Timer = time.AfterFunc(seleniumHub.nodeTimeout, func() {
-				seleniumHub.DeleteNode(machine.Configuration.Url)
-			})
+		if kind == registeredNode {
+			seleniumNode.Timer = time.AfterFunc(seleniumHub.nodeTimeout, func() {
+					log.Warn("Node did not send heartbeat in time", "node", machine.Configuration.Url)
+					seleniumHub.emit(Event{Type: EventNodeUnhealthy, Node: machine.Configuration.Url,
+						Message: "heartbeat timeout"})
+					seleniumHub.DeleteNode(context.Background(), machine.Configuration.Url)
+				})
+		}
+		log.InfoContext(ctx, "Node registered", "node", machine.Configuration.Url, "slots", len(sessions))
+		seleniumHub.emit(Event{Type: EventNodeRegistered, Node: machine.Configuration.Url})
 		return true
 	}
+	log.WarnContext(ctx, "Node has no WebDriver slots", "node", machine.Configuration.Url)
 	return false
 }
 
-func (seleniumHub *Hub) DeleteNode(nodeId string) {
-	log.Println("Waiting lock for delete node", nodeId)
+func (seleniumHub *Hub) DeleteNode(ctx context.Context, nodeId string) {
+	log.DebugContext(ctx, "Waiting lock for delete node", "node", nodeId)
 	seleniumHub.nodesLocker.Lock()
 	defer seleniumHub.nodesLocker.Unlock()
 	if seleniumNode, found := seleniumHub.nodes[nodeId]; found {
-		log.Println("Node", nodeId, "was found. Delete it.")
-		seleniumNode.Timer.Stop()
+		log.InfoContext(ctx, "Delete node", "node", nodeId)
+		if seleniumNode.Timer != nil {
+			seleniumNode.Timer.Stop()
+		}
 		delete(seleniumHub.nodes, nodeId)
 		seleniumHub.availableLocker.Lock()
 		defer seleniumHub.availableLocker.Unlock()
+		var available []*session.Session
 		for _, seleniumSession := range seleniumHub.availableSessions {
-			if seleniumSession.Node == seleniumNode {
+			if seleniumSession.Node != seleniumNode {
+				available = append(available, seleniumSession)
+			} else {
 				seleniumSession.Exit()
-				if seleniumSession.Status == session.Active {
-					go func() {
-						seleniumHub.activeLocker.Lock()
-						defer seleniumHub.activeLocker.Unlock()
-						delete(seleniumHub.activeSessions, seleniumSession.Id)
-					}()
-				}
+				go seleniumHub.killSession(ctx, seleniumSession)
 			}
 		}
+		seleniumHub.availableSessions = available
+		seleniumHub.emit(Event{Type: EventNodeRemoved, Node: nodeId})
 	}
 }
 
+// killSession ends a session of a deleted node the same way FreeSession does,
+// unless the session is not active or was freed already.
+// The slot is looked up by pointer: fields of a slot which is not active are cleared by its processor.
+func (seleniumHub *Hub) killSession(ctx context.Context, seleniumSession *session.Session) {
+	var found bool
+	seleniumHub.activeLocker.Lock()
+	for sessionId, active := range seleniumHub.activeSessions {
+		if active == seleniumSession {
+			delete(seleniumHub.activeSessions, sessionId)
+			found = true
+			break
+		}
+	}
+	seleniumHub.activeLocker.Unlock()
+	if !found {
+		return
+	}
+	log.WarnContext(ctx, "Session is killed with its node", "session", seleniumSession.Id,
+		"node", seleniumSession.Node.Url)
+	info := newSessionInfo(seleniumSession)
+	seleniumHub.sessionEnded(info)
+	seleniumHub.quotas.release(seleniumSession.Tenant)
+	seleniumHub.emitSession(EventSessionKilled, info)
+}
+
 func (seleniumHub *Hub) GetNodeData(nodeId string) ([]byte, bool) {
 	seleniumHub.nodesLocker.RLock()
 	defer seleniumHub.nodesLocker.RUnlock()
 	if seleniumNode, found := seleniumHub.nodes[nodeId]; found {
-		seleniumNode.Timer.Reset(seleniumHub.nodeTimeout)
+		if seleniumNode.Timer != nil {
+			seleniumNode.Timer.Reset(seleniumHub.nodeTimeout)
+		}
 		return seleniumNode.ApiProxyResponse, true
 	}
 	return nil, false
 }
 
+// GetSessionUrl returns URL of WebDriver API of the node which runs the session and prolongs the session.
 func (seleniumHub *Hub) GetSessionUrl(sessionId string) (string, bool) {
 	seleniumHub.activeLocker.RLock()
 	defer seleniumHub.activeLocker.RUnlock()
 	if seleniumSession, found := seleniumHub.activeSessions[sessionId]; found {
 		seleniumSession.Timer.Reset(seleniumHub.sessionTimeout)
-		return seleniumSession.Node.Url, true
+		return seleniumSession.Node.Endpoint(), true
 	}
 	return "", false
 }
 
+// GetSessionWebSocket returns a BiDi or CDP URL of the session on the node and prolongs the session.
+// A loopback host which a browser driver reports is replaced by the host of the node.
+func (seleniumHub *Hub) GetSessionWebSocket(sessionId string, protocol string) (string, bool) {
+	seleniumHub.activeLocker.RLock()
+	defer seleniumHub.activeLocker.RUnlock()
+	seleniumSession, found := seleniumHub.activeSessions[sessionId]
+	if !found {
+		return "", false
+	}
+	webSocketUrl, found := seleniumSession.WebSockets[protocol]
+	if !found {
+		return "", false
+	}
+	seleniumSession.Timer.Reset(seleniumHub.sessionTimeout)
+	return resolveWebSocket(seleniumSession.Node, webSocketUrl)
+}
+
+// GetSessionVnc returns a WebSocket URL of the VNC server on the node of the session.
+func (seleniumHub *Hub) GetSessionVnc(sessionId string) (string, bool) {
+	seleniumHub.activeLocker.RLock()
+	defer seleniumHub.activeLocker.RUnlock()
+	seleniumSession, found := seleniumHub.activeSessions[sessionId]
+	if !found || seleniumSession.Node.VncUrl == "" {
+		return "", false
+	}
+	return resolveWebSocket(seleniumSession.Node, seleniumSession.Node.VncUrl)
+}
+
+// resolveWebSocket makes a WebSocket URL reported by the node reachable from the hub: a loopback host
+// is replaced by the host of the node and http:// schemes become ws://.
+func resolveWebSocket(seleniumNode *session.Node, webSocketUrl string) (string, bool) {
+	address, err := neturl.Parse(webSocketUrl)
+	if err != nil {
+		return "", false
+	}
+	switch address.Scheme {
+	case "http":
+		address.Scheme = "ws"
+	case "https":
+		address.Scheme = "wss"
+	}
+	switch address.Hostname() {
+	case "localhost", "127.0.0.1", "::1", "0.0.0.0":
+		if node, err := neturl.Parse(seleniumNode.Url); err == nil {
+			if port := address.Port(); port != "" {
+				address.Host = net.JoinHostPort(node.Hostname(), port)
+			} else {
+				address.Host = node.Hostname()
+			}
+		}
+	}
+	return address.String(), true
+}
+
+// IsActive reports whether the session is running. Unlike GetSessionUrl it does not prolong the session.
+func (seleniumHub *Hub) IsActive(sessionId string) bool {
+	seleniumHub.activeLocker.RLock()
+	defer seleniumHub.activeLocker.RUnlock()
+	_, found := seleniumHub.activeSessions[sessionId]
+	return found
+}
+
 func (seleniumHub *Hub) GetSessions() (sessions []session.Session) {
 	seleniumHub.activeLocker.RLock()
 	defer seleniumHub.activeLocker.RUnlock()
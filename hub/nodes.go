@@ -0,0 +1,88 @@
+package hub
+
+import (
+	"sort"
+
+	"selenium-hub/session"
+)
+
+// NodeInfo describes a registered node for the node API.
+type NodeInfo struct {
+	Url       string                `json:"url"`
+	Labels    map[string]string     `json:"labels,omitempty"`
+	VncUrl    string                `json:"vncUrl,omitempty"`
+	Load      float64               `json:"load"`
+	Draining  bool                  `json:"draining"`
+	SingleUse bool                  `json:"singleUse"`
+	Penalized bool                  `json:"penalized"`
+	Circuit   session.BreakerStatus `json:"circuit"`
+}
+
+// GetNodes returns registered nodes ordered by URL.
+func (seleniumHub *Hub) GetNodes() []NodeInfo {
+	seleniumHub.nodesLocker.RLock()
+	defer seleniumHub.nodesLocker.RUnlock()
+	nodes := []NodeInfo{}
+	for _, seleniumNode := range seleniumHub.nodes {
+		nodes = append(nodes, NodeInfo{
+			Url:       seleniumNode.Url,
+			Labels:    seleniumNode.Labels,
+			VncUrl:    seleniumNode.VncUrl,
+			Load:      seleniumNode.Load(),
+			Draining:  seleniumNode.Draining.Load(),
+			SingleUse: seleniumNode.SingleUse,
+			Penalized: seleniumNode.Penalized(),
+			Circuit:   seleniumNode.Breaker.Status(),
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Url < nodes[j].Url
+	})
+	return nodes
+}
+
+// RecordNodeRequest counts a proxied request of the session in the circuit breaker of its node.
+func (seleniumHub *Hub) RecordNodeRequest(sessionId string, failed bool) {
+	seleniumHub.activeLocker.RLock()
+	seleniumSession, found := seleniumHub.activeSessions[sessionId]
+	seleniumHub.activeLocker.RUnlock()
+	if found {
+		state, changed := seleniumSession.Node.Breaker.Record(failed)
+		seleniumHub.circuitChanged(seleniumSession.Node, state, changed)
+	}
+}
+
+// recordSessionResult counts creation of the session in the circuit breaker of its node.
+func (seleniumHub *Hub) recordSessionResult(seleniumSession *session.Session, failed bool) {
+	state, changed := seleniumSession.Node.Breaker.RecordSession(seleniumSession, failed)
+	seleniumHub.circuitChanged(seleniumSession.Node, state, changed)
+}
+
+func (seleniumHub *Hub) circuitChanged(seleniumNode *session.Node, state session.BreakerState, changed bool) {
+	if !changed {
+		return
+	}
+	if state == session.Open {
+		log.Warn("Circuit opened, node is removed from scheduling", "node", seleniumNode.Url,
+			"coolDown", seleniumHub.breaker.CoolDown.Duration)
+		seleniumHub.emit(Event{Type: EventCircuitOpened, Node: seleniumNode.Url})
+	} else {
+		log.Info("Circuit closed", "node", seleniumNode.Url)
+		seleniumHub.emit(Event{Type: EventCircuitClosed, Node: seleniumNode.Url})
+	}
+}
+
+// newBreaker returns a breaker for a registering node. A node which registers again keeps its breaker,
+// so restarting a broken node does not reset it.
+func (seleniumHub *Hub) newBreaker(nodeUrl string) *session.Breaker {
+	if seleniumHub.breaker == nil {
+		return nil
+	}
+	seleniumHub.nodesLocker.RLock()
+	defer seleniumHub.nodesLocker.RUnlock()
+	if seleniumNode, found := seleniumHub.nodes[nodeUrl]; found && seleniumNode.Breaker != nil {
+		return seleniumNode.Breaker
+	}
+	return session.NewBreaker(seleniumHub.breaker.Window, seleniumHub.breaker.Threshold,
+		seleniumHub.breaker.MinRequests, seleniumHub.breaker.CoolDown.Duration)
+}
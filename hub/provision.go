@@ -0,0 +1,139 @@
+package hub
+
+import (
+	"context"
+	"time"
+
+	"selenium-hub/provisioner"
+	"selenium-hub/session"
+	"selenium-hub/translator"
+)
+
+// provisionPoll is how often a reservation retries provisioners which have no free capacity.
+const provisionPoll = time.Second
+
+// Provisioner starts single-use nodes when registered nodes have no slot for requested capabilities.
+type Provisioner interface {
+	// Provision starts a node for capabilities. It returns provisioner.ErrUnsupported
+	// when capabilities can not be served by this provisioner.
+	Provision(capabilities *session.Capabilities) (*translator.Proxy, error)
+	// Release stops the node started by Provision.
+	Release(node *session.Node)
+	// Capacity returns how many nodes may be started right now. Provisioners without capacity are not asked.
+	Capacity() int
+}
+
+// AddProvisioner registers a provisioner. Provisioners are consulted in the order they were added.
+func (seleniumHub *Hub) AddProvisioner(nodeProvisioner Provisioner) {
+	seleniumHub.provisionersLocker.Lock()
+	defer seleniumHub.provisionersLocker.Unlock()
+	seleniumHub.provisioners = append(seleniumHub.provisioners, nodeProvisioner)
+}
+
+// provision starts a single-use node for capabilities which are not served by registered nodes.
+// It returns nil when no provisioner supports capabilities and provisioner.ErrNoCapacity when
+// the provisioners which support them have already started as many nodes as they may.
+func (seleniumHub *Hub) provision(ctx context.Context, capabilities *session.Capabilities) (*session.Node, error) {
+	seleniumHub.provisionersLocker.RLock()
+	provisioners := seleniumHub.provisioners
+	seleniumHub.provisionersLocker.RUnlock()
+	var busy bool
+	for _, nodeProvisioner := range provisioners {
+		if nodeProvisioner.Capacity() <= 0 {
+			// Whether an exhausted provisioner supports capabilities is not known, so the reservation waits for it.
+			busy = true
+			continue
+		}
+		machine, err := nodeProvisioner.Provision(capabilities)
+		if err == provisioner.ErrUnsupported {
+			continue
+		}
+		if err == provisioner.ErrNoCapacity {
+			busy = true
+			continue
+		}
+		if err != nil {
+			log.ErrorContext(ctx, "Could not provision node", "capabilities", capabilities, "error", err)
+			continue
+		}
+		nodeId := machine.Configuration.Url
+		seleniumHub.provisionersLocker.Lock()
+		seleniumHub.provisioned[nodeId] = nodeProvisioner
+		seleniumHub.provisionersLocker.Unlock()
+		log.InfoContext(ctx, "Node provisioned", "node", nodeId, "capabilities", capabilities)
+		if seleniumHub.registerNode(ctx, machine, provisionedNode) {
+			seleniumHub.nodesLocker.RLock()
+			seleniumNode, found := seleniumHub.nodes[nodeId]
+			seleniumHub.nodesLocker.RUnlock()
+			if found {
+				return seleniumNode, nil
+			}
+		}
+		seleniumHub.releaseNode(ctx, nodeId)
+	}
+	if busy {
+		return nil, provisioner.ErrNoCapacity
+	}
+	return nil, nil
+}
+
+// awaitProvision provisions a node for the reservation. While provisioners have no free capacity,
+// it waits like a queued request until one of their nodes is released or the queue timeout expires.
+func (seleniumHub *Hub) awaitProvision(ctx context.Context, capabilities *session.Capabilities,
+	timeout <-chan time.Time) (*session.Node, error) {
+	for {
+		seleniumNode, err := seleniumHub.provision(ctx, capabilities)
+		if err != provisioner.ErrNoCapacity {
+			return seleniumNode, err
+		}
+		log.DebugContext(ctx, "Provisioners have no free capacity", "capabilities", capabilities)
+		select {
+		case <-time.After(provisionPoll):
+		case <-timeout:
+			log.WarnContext(ctx, "No node was provisioned in time", "capabilities", capabilities,
+				"timeout", seleniumHub.queueTimeout)
+			seleniumHub.emit(Event{Type: EventQueueTimeout, Capabilities: capabilities})
+			return nil, ErrQueueTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-seleniumHub.draining:
+			return nil, ErrDraining
+		}
+	}
+}
+
+// reserveProvisioned reserves a slot of the node which was provisioned for the request.
+// The node is released when the request does not get its slot.
+func (seleniumHub *Hub) reserveProvisioned(ctx context.Context, capabilities *session.Capabilities, tenant string,
+	timeout <-chan time.Time, seleniumNode *session.Node) (*session.Session, error) {
+	err := ErrNoSlot
+	cs := seleniumHub.getSortedSessions(*capabilities, seleniumNode, nil)
+	if cs.Len() > 0 {
+		var seleniumSession *session.Session
+		if seleniumSession, err = seleniumHub.queueForSlot(ctx, capabilities, tenant, timeout, cs); err == nil {
+			return seleniumSession, nil
+		}
+	}
+	log.WarnContext(ctx, "Provisioned node was not used", "node", seleniumNode.Url, "capabilities", capabilities,
+		"error", err)
+	go seleniumHub.releaseNode(ctx, seleniumNode.Url)
+	return nil, err
+}
+
+// releaseNode removes a single-use node and returns it to its provisioner.
+func (seleniumHub *Hub) releaseNode(ctx context.Context, nodeId string) {
+	seleniumHub.nodesLocker.RLock()
+	seleniumNode, found := seleniumHub.nodes[nodeId]
+	seleniumHub.nodesLocker.RUnlock()
+	seleniumHub.DeleteNode(ctx, nodeId)
+	seleniumHub.provisionersLocker.Lock()
+	nodeProvisioner, provisioned := seleniumHub.provisioned[nodeId]
+	delete(seleniumHub.provisioned, nodeId)
+	seleniumHub.provisionersLocker.Unlock()
+	if provisioned {
+		if !found {
+			seleniumNode = session.NewNode(nodeId, 1)
+		}
+		nodeProvisioner.Release(seleniumNode)
+	}
+}
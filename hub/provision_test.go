@@ -0,0 +1,133 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"selenium-hub/config"
+	"selenium-hub/provisioner"
+	"selenium-hub/session"
+	"selenium-hub/translator"
+)
+
+// fakeProvisioner starts imaginary single-slot nodes up to its limit.
+type fakeProvisioner struct {
+	locker   sync.Mutex
+	max      int
+	started  int
+	running  map[string]bool
+	released chan string
+}
+
+func newFakeProvisioner(max int) *fakeProvisioner {
+	return &fakeProvisioner{max: max, running: make(map[string]bool), released: make(chan string, 10)}
+}
+
+func (fake *fakeProvisioner) Provision(capabilities *session.Capabilities) (*translator.Proxy, error) {
+	if capabilities.BrowserName != "chrome" {
+		return nil, provisioner.ErrUnsupported
+	}
+	fake.locker.Lock()
+	defer fake.locker.Unlock()
+	if len(fake.running) >= fake.max {
+		return nil, provisioner.ErrNoCapacity
+	}
+	fake.started++
+	nodeUrl := fmt.Sprintf("http://node%d:4444", fake.started)
+	fake.running[nodeUrl] = true
+	return translator.NewSingleSlotProxy(nodeUrl, session.Capabilities{BrowserName: "chrome"}), nil
+}
+
+func (fake *fakeProvisioner) Release(node *session.Node) {
+	fake.locker.Lock()
+	delete(fake.running, node.Url)
+	fake.locker.Unlock()
+	fake.released <- node.Url
+}
+
+func (fake *fakeProvisioner) Capacity() int {
+	fake.locker.Lock()
+	defer fake.locker.Unlock()
+	return fake.max - len(fake.running)
+}
+
+func (fake *fakeProvisioner) waitReleased(t *testing.T, nodeUrl string) {
+	t.Helper()
+	select {
+	case released := <-fake.released:
+		if released != nodeUrl {
+			t.Fatalf("released %s, want %s", released, nodeUrl)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("node %s was not released", nodeUrl)
+	}
+}
+
+func TestProvisionedNodes(t *testing.T) {
+	configuration := config.New()
+	configuration.NewSessionWaitTimeout.Duration = 5 * time.Second
+	seleniumHub, err := New(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeProvisioner(2)
+	seleniumHub.AddProvisioner(fake)
+	chrome := &session.Capabilities{BrowserName: "chrome"}
+
+	// Concurrent requests get their own nodes instead of queueing on a busy single-use slot.
+	sessions := make(chan *session.Session, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			reserved, err := seleniumHub.ReserveSession(context.Background(), chrome)
+			if err != nil {
+				t.Error(err)
+			}
+			sessions <- reserved
+		}()
+	}
+	first, second := <-sessions, <-sessions
+	if first == nil || second == nil {
+		t.FailNow()
+	}
+	if first.Node == second.Node {
+		t.Fatalf("both sessions were reserved on %s", first.Node.Url)
+	}
+
+	// A request over the provisioner limit waits for a released node and gets a new one.
+	third := make(chan *session.Session, 1)
+	go func() {
+		reserved, err := seleniumHub.ReserveSession(context.Background(), chrome)
+		if err != nil {
+			t.Error(err)
+		}
+		third <- reserved
+	}()
+	select {
+	case reserved := <-third:
+		t.Fatalf("session was reserved on %v while provisioners had no capacity", reserved)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// A session which the node could not create releases the node.
+	seleniumHub.CancelSession(context.Background(), first)
+	fake.waitReleased(t, first.Node.Url)
+	reserved := <-third
+	if reserved == nil {
+		t.FailNow()
+	}
+	if reserved.Node == first.Node || reserved.Node == second.Node {
+		t.Errorf("session was reserved on a used node %s", reserved.Node.Url)
+	}
+
+	// A freed session releases its node too.
+	second.Id = "second"
+	seleniumHub.StartSession(second)
+	seleniumHub.FreeSession(context.Background(), second.Id)
+	fake.waitReleased(t, second.Node.Url)
+	if _, found := seleniumHub.findNode(second.Node.Url); found {
+		t.Errorf("released node %s is still registered", second.Node.Url)
+	}
+}
@@ -0,0 +1,56 @@
+package hub
+
+import (
+	"sort"
+	"time"
+
+	"selenium-hub/session"
+)
+
+// QueuedRequest is a new session request which waits for a free slot.
+type QueuedRequest struct {
+	Tenant            string                `json:"tenant"`
+	Priority          int                   `json:"priority"`
+	EffectivePriority int                   `json:"effectivePriority"`
+	Capabilities      *session.Capabilities `json:"capabilities"`
+	Enqueued          time.Time             `json:"enqueued"`
+	element           *session.QueueElement
+}
+
+func (seleniumHub *Hub) enqueue(element *session.QueueElement, tenant string, capabilities *session.Capabilities) {
+	seleniumHub.queueLocker.Lock()
+	defer seleniumHub.queueLocker.Unlock()
+	seleniumHub.queue[element] = &QueuedRequest{
+		Tenant:       tenant,
+		Priority:     element.Priority,
+		Capabilities: capabilities,
+		Enqueued:     element.Enqueued,
+		element:      element,
+	}
+}
+
+func (seleniumHub *Hub) dequeue(element *session.QueueElement) {
+	seleniumHub.queueLocker.Lock()
+	defer seleniumHub.queueLocker.Unlock()
+	delete(seleniumHub.queue, element)
+}
+
+// GetQueue returns waiting requests in the order they are served.
+func (seleniumHub *Hub) GetQueue() []QueuedRequest {
+	seleniumHub.queueLocker.RLock()
+	defer seleniumHub.queueLocker.RUnlock()
+	now := time.Now()
+	queue := []QueuedRequest{}
+	for _, request := range seleniumHub.queue {
+		queued := *request
+		queued.EffectivePriority = request.element.EffectivePriority(now)
+		queue = append(queue, queued)
+	}
+	sort.SliceStable(queue, func(i, j int) bool {
+		if queue[i].EffectivePriority != queue[j].EffectivePriority {
+			return queue[i].EffectivePriority > queue[j].EffectivePriority
+		}
+		return queue[i].Enqueued.Before(queue[j].Enqueued)
+	})
+	return queue
+}
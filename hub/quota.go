@@ -0,0 +1,161 @@
+package hub
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"selenium-hub/config"
+)
+
+// defaultTenant is used for clients without identity and team and limits tenants which are not configured.
+const defaultTenant = "default"
+
+// Usage is a quota state of a tenant.
+type Usage struct {
+	Active      int `json:"active"`
+	Queued      int `json:"queued"`
+	MaxSessions int `json:"maxSessions"`
+	MaxQueued   int `json:"maxQueued"`
+	// released is closed and replaced every time a session of the tenant is released.
+	released chan struct{}
+}
+
+// quotas counts sessions of tenants. A session is counted as active from its reservation until it is freed.
+type quotas struct {
+	tenants map[string]*config.Tenant
+	members map[string]string
+	usage   map[string]*Usage
+	locker  *sync.Mutex
+	// draining is closed when the hub stops accepting new sessions, so waiting requests fail.
+	draining <-chan struct{}
+}
+
+func newQuotas(tenants map[string]*config.Tenant, draining <-chan struct{}) *quotas {
+	var quota *quotas = new(quotas)
+	quota.tenants = tenants
+	quota.draining = draining
+	quota.members = make(map[string]string)
+	quota.usage = make(map[string]*Usage)
+	quota.locker = new(sync.Mutex)
+	for name, tenant := range tenants {
+		for _, member := range tenant.Members {
+			quota.members[member] = name
+		}
+		quota.get(name)
+	}
+	return quota
+}
+
+// tenant chooses a tenant by identity of the client and hub:team capability. A team is used only when it is
+// configured without members, so a client can not get fresh limits by inventing a team.
+func (quota *quotas) tenant(identity string, team string) string {
+	if name, found := quota.members[identity]; found {
+		return name
+	}
+	if team != "" {
+		if tenant, found := quota.tenants[team]; found && len(tenant.Members) == 0 {
+			return team
+		}
+	}
+	if identity != "" {
+		return identity
+	}
+	return defaultTenant
+}
+
+// priority returns the default priority of the tenant.
+func (quota *quotas) priority(name string) int {
+	tenant, configured := quota.tenants[name]
+	if !configured {
+		tenant, configured = quota.tenants[defaultTenant]
+	}
+	if configured {
+		return tenant.Priority
+	}
+	return 0
+}
+
+// get returns usage of the tenant. It must be called under the lock.
+func (quota *quotas) get(name string) *Usage {
+	usage, found := quota.usage[name]
+	if !found {
+		usage = &Usage{released: make(chan struct{})}
+		tenant, configured := quota.tenants[name]
+		if !configured {
+			tenant, configured = quota.tenants[defaultTenant]
+		}
+		if configured {
+			usage.MaxSessions = tenant.MaxSessions
+			usage.MaxQueued = tenant.MaxQueued
+		}
+		quota.usage[name] = usage
+	}
+	return usage
+}
+
+// acquire waits until the tenant may have one more session.
+func (quota *quotas) acquire(ctx context.Context, name string, timeout <-chan time.Time) error {
+	quota.locker.Lock()
+	defer quota.locker.Unlock()
+	usage := quota.get(name)
+	if usage.MaxSessions == 0 || usage.Active < usage.MaxSessions {
+		usage.Active++
+		return nil
+	}
+	if usage.MaxQueued > 0 && usage.Queued >= usage.MaxQueued {
+		return ErrQuotaExceeded
+	}
+	usage.Queued++
+	defer func() {
+		usage.Queued--
+	}()
+	for usage.Active >= usage.MaxSessions {
+		released := usage.released
+		quota.locker.Unlock()
+		select {
+		case <-released:
+			quota.locker.Lock()
+		case <-timeout:
+			quota.locker.Lock()
+			return ErrQueueTimeout
+		case <-ctx.Done():
+			quota.locker.Lock()
+			return ctx.Err()
+		case <-quota.draining:
+			quota.locker.Lock()
+			return ErrDraining
+		}
+	}
+	usage.Active++
+	return nil
+}
+
+func (quota *quotas) release(name string) {
+	if name == "" {
+		return
+	}
+	quota.locker.Lock()
+	defer quota.locker.Unlock()
+	usage := quota.get(name)
+	if usage.Active > 0 {
+		usage.Active--
+	}
+	close(usage.released)
+	usage.released = make(chan struct{})
+}
+
+func (quota *quotas) snapshot() map[string]Usage {
+	quota.locker.Lock()
+	defer quota.locker.Unlock()
+	usages := make(map[string]Usage)
+	for name, usage := range quota.usage {
+		usages[name] = *usage
+	}
+	return usages
+}
+
+// GetQuotas returns quota usage of every known tenant.
+func (seleniumHub *Hub) GetQuotas() map[string]Usage {
+	return seleniumHub.quotas.snapshot()
+}
@@ -0,0 +1,35 @@
+package hub
+
+import (
+	"testing"
+
+	"selenium-hub/config"
+)
+
+func TestQuotaTenant(t *testing.T) {
+	quota := newQuotas(map[string]*config.Tenant{
+		"default": {MaxSessions: 1},
+		"open":    {MaxSessions: 5},
+		"closed":  {MaxSessions: 5, Members: []string{"alice"}},
+	}, nil)
+	tests := []struct {
+		identity string
+		team     string
+		want     string
+	}{
+		{"", "", defaultTenant},
+		{"bob", "", "bob"},
+		{"alice", "", "closed"},
+		{"bob", "open", "open"},
+		{"alice", "open", "closed"},
+		{"bob", "closed", "bob"},
+		// Unknown teams share limits of the client instead of getting fresh ones.
+		{"bob", "invented", "bob"},
+		{"", "invented", defaultTenant},
+	}
+	for _, test := range tests {
+		if got := quota.tenant(test.identity, test.team); got != test.want {
+			t.Errorf("tenant(%q, %q) = %q, want %q", test.identity, test.team, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package hub
+
+import "context"
+
+// CaptureScreenshot stores a final screenshot of the active session if screenshots are enabled.
+func (seleniumHub *Hub) CaptureScreenshot(ctx context.Context, sessionId string) {
+	if seleniumHub.screenshots == nil {
+		return
+	}
+	seleniumHub.activeLocker.RLock()
+	seleniumSession, found := seleniumHub.activeSessions[sessionId]
+	seleniumHub.activeLocker.RUnlock()
+	if !found {
+		return
+	}
+	if err := seleniumHub.screenshots.Capture(ctx, seleniumSession.Node.Endpoint(), sessionId); err != nil {
+		log.WarnContext(ctx, "Could not capture screenshot", "session", sessionId, "node", seleniumSession.Node.Url, "error", err)
+		return
+	}
+	log.InfoContext(ctx, "Screenshot captured", "session", sessionId, "node", seleniumSession.Node.Url)
+	seleniumHub.SetArtifact(sessionId, "screenshot", "/grid/api/sessions/"+sessionId+"/screenshot")
+}
+
+// GetScreenshot returns a path to the final screenshot of the session.
+func (seleniumHub *Hub) GetScreenshot(sessionId string) (string, bool) {
+	if seleniumHub.screenshots == nil {
+		return "", false
+	}
+	return seleniumHub.screenshots.Find(sessionId)
+}
@@ -0,0 +1,88 @@
+package hub
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"selenium-hub/proxy"
+	"selenium-hub/session"
+)
+
+// drainPoll is how often Shutdown checks whether active sessions are finished.
+const drainPoll = 100 * time.Millisecond
+
+// Drain stops accepting new sessions. Active sessions keep working.
+func (seleniumHub *Hub) Drain(ctx context.Context) {
+	seleniumHub.drainOnce.Do(func() {
+		log.InfoContext(ctx, "Hub is draining, new sessions are not accepted")
+		close(seleniumHub.draining)
+	})
+}
+
+func (seleniumHub *Hub) IsDraining() bool {
+	select {
+	case <-seleniumHub.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// Shutdown drains the hub, fails queued requests, waits up to timeout for active sessions to finish,
+// deletes remaining sessions on their nodes and removes all nodes.
+func (seleniumHub *Hub) Shutdown(ctx context.Context, timeout time.Duration) {
+	seleniumHub.Drain(ctx)
+	deadline := time.Now().Add(timeout)
+	// Queued requests leave the queue as soon as the hub drains. They must not take slots which are freed below.
+	for seleniumHub.queuedCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPoll)
+	}
+	for seleniumHub.activeCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPoll)
+	}
+	for _, seleniumSession := range seleniumHub.GetSessions() {
+		log.WarnContext(ctx, "Session is still active, delete it", "session", seleniumSession.Id, "node", seleniumSession.Node.Url)
+		seleniumHub.emitSession(EventSessionKilled, newSessionInfo(&seleniumSession))
+		deleteRemoteSession(ctx, seleniumSession.Node.Endpoint(), seleniumSession.Id)
+		seleniumHub.FreeSession(ctx, seleniumSession.Id)
+	}
+	seleniumHub.availableLocker.RLock()
+	for _, seleniumSession := range seleniumHub.availableSessions {
+		if seleniumSession.Status == session.Prestarted && seleniumSession.Id != "" {
+			deleteRemoteSession(ctx, seleniumSession.Node.Endpoint(), seleniumSession.Id)
+		}
+	}
+	seleniumHub.availableLocker.RUnlock()
+	seleniumHub.nodesLocker.RLock()
+	var nodeIds []string
+	for nodeId := range seleniumHub.nodes {
+		nodeIds = append(nodeIds, nodeId)
+	}
+	seleniumHub.nodesLocker.RUnlock()
+	for _, nodeId := range nodeIds {
+		seleniumHub.releaseNode(ctx, nodeId)
+	}
+	log.InfoContext(ctx, "Hub is stopped")
+}
+
+func (seleniumHub *Hub) activeCount() int {
+	seleniumHub.activeLocker.RLock()
+	defer seleniumHub.activeLocker.RUnlock()
+	return len(seleniumHub.activeSessions)
+}
+
+func (seleniumHub *Hub) queuedCount() int {
+	seleniumHub.queueLocker.RLock()
+	defer seleniumHub.queueLocker.RUnlock()
+	return len(seleniumHub.queue)
+}
+
+func deleteRemoteSession(ctx context.Context, nodeUrl string, sessionId string) {
+	var r *http.Request = new(http.Request)
+	r.Method = "DELETE"
+	r.RequestURI = "/wd/hub/session/" + sessionId
+	if _, _, err := proxy.ProxyRequest(nodeUrl, r, nil); err != nil {
+		log.ErrorContext(ctx, "Could not delete session", "session", sessionId, "node", nodeUrl, "error", err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"selenium-hub/translator"
+)
+
+// limitBody rejects request bodies larger than maxBodySize bytes. Zero means no limit.
+func limitBody(maxBodySize int64, handler http.Handler) http.Handler {
+	if maxBodySize <= 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxBodySize {
+			log.WarnContext(r.Context(), "Request body is too large", "method", r.Method, "path", r.URL.Path,
+				"size", r.ContentLength, "limit", maxBodySize)
+			bodyTooLarge(w)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// isBodyTooLarge reports whether reading a request body failed because of the limit.
+func isBodyTooLarge(err error) bool {
+	var maxBytesError *http.MaxBytesError
+	return errors.As(err, &maxBytesError)
+}
+
+func bodyTooLarge(w http.ResponseWriter) {
+	answer := answer{}
+	answer.Message = "Request body is too large."
+	answer.LocalizedMessage = "Тело запроса слишком большое."
+	setHttpHeaders(w)
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	w.Write(translator.GetResponse(13, answer))
+}
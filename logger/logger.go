@@ -0,0 +1,130 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+type contextKey uint8
+
+const requestIdKey contextKey = iota
+
+var (
+	locker               = new(sync.RWMutex)
+	base    slog.Handler = slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	general              = slog.LevelInfo
+	levels               = make(map[string]slog.Level)
+)
+
+// Configure sets output format ("json" or "logfmt"), the default level and levels of subsystems.
+func Configure(output io.Writer, format string, level string, subsystems map[string]string) error {
+	var handler slog.Handler
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if strings.ToLower(format) == "json" {
+		handler = slog.NewJSONHandler(output, options)
+	} else {
+		handler = slog.NewTextHandler(output, options)
+	}
+	defaultLevel, err := parseLevel(level)
+	if err != nil {
+		return err
+	}
+	subsystemLevels := make(map[string]slog.Level)
+	for subsystem, value := range subsystems {
+		if subsystemLevels[subsystem], err = parseLevel(value); err != nil {
+			return err
+		}
+	}
+	locker.Lock()
+	defer locker.Unlock()
+	base = handler
+	general = defaultLevel
+	levels = subsystemLevels
+	return nil
+}
+
+// For returns a logger of the subsystem. It may be created before Configure is called.
+func For(subsystem string) *slog.Logger {
+	return slog.New(&handler{subsystem: subsystem}).With("subsystem", subsystem)
+}
+
+// WithRequestId stores request id in the context. Loggers add it to every record logged with the context.
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey, requestId)
+}
+
+func RequestId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestId, _ := ctx.Value(requestIdKey).(string)
+	return requestId
+}
+
+// NewRequestId generates a random request id.
+func NewRequestId() string {
+	data := make([]byte, 8)
+	rand.Read(data)
+	return hex.EncodeToString(data)
+}
+
+func parseLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if value == "" {
+		return slog.LevelInfo, nil
+	}
+	err := level.UnmarshalText([]byte(value))
+	return level, err
+}
+
+// handler resolves the output and the level on every call, so loggers created at package
+// initialization follow the configuration loaded later.
+type handler struct {
+	subsystem string
+	attrs     []slog.Attr
+	groups    []string
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	locker.RLock()
+	defer locker.RUnlock()
+	minimal, found := levels[h.subsystem]
+	if !found {
+		minimal = general
+	}
+	return level >= minimal
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	if requestId := RequestId(ctx); requestId != "" {
+		record.AddAttrs(slog.String("request_id", requestId))
+	}
+	locker.RLock()
+	output := base
+	locker.RUnlock()
+	for _, group := range h.groups {
+		output = output.WithGroup(group)
+	}
+	if len(h.attrs) > 0 {
+		output = output.WithAttrs(h.attrs)
+	}
+	return output.Handle(ctx, record)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.groups = append(append([]string{}, h.groups...), name)
+	return &clone
+}
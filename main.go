@@ -11,11 +11,24 @@ import (
 	"fmt"
 	"bytes"
 	"runtime"
-	"log"
+	"flag"
+	"expvar"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"context"
+	"selenium-hub/auth"
+	"selenium-hub/config"
+	"selenium-hub/driver"
 	"selenium-hub/proxy"
 	"selenium-hub/translator"
 	"selenium-hub/session"
 	"selenium-hub/hub"
+	"selenium-hub/logger"
+	"selenium-hub/recorder"
+	"selenium-hub/webhook"
 )
 
 type answer struct {
@@ -23,12 +36,70 @@ type answer struct {
 	LocalizedMessage string `json:"localizedMessage"`
 }
 
-var seleniumHub = hub.New()
+var seleniumHub *hub.Hub
+
+var log = logger.For("http")
+
+// commandRecorder is nil when the command audit log is disabled.
+var commandRecorder *recorder.Recorder
+
+// newSessionAttempts is how many nodes are tried to create one session.
+var newSessionAttempts int
 
 func main() {
+	configPath := flag.String("config", "", "Path to the hub configuration file")
+	flag.Parse()
+	configuration, err := config.Load(*configPath)
+	if err != nil {
+		log.Error("Could not load configuration", "error", err)
+		os.Exit(1)
+	}
+	logging := configuration.Log
+	if err = logger.Configure(os.Stderr, logging.Format, logging.Level, logging.Subsystems); err != nil {
+		log.Error("Invalid log configuration", "error", err)
+		os.Exit(1)
+	}
+	if configuration.NodeTLS != nil {
+		nodeTLS, err := newNodeTLS(configuration.NodeTLS)
+		if err != nil {
+			log.Error("Invalid node TLS configuration", "error", err)
+			os.Exit(1)
+		}
+		proxy.Configure(nodeTLS)
+	}
+	seleniumHub, err = hub.New(configuration)
+	if err != nil {
+		log.Error("Could not create hub", "error", err)
+		os.Exit(1)
+	}
+	expvar.Publish("tenants", expvar.Func(func() interface{} {
+		return seleniumHub.GetQuotas()
+	}))
+	for _, webhookConfiguration := range configuration.Webhooks {
+		seleniumHub.Subscribe(webhook.New(webhookConfiguration).Notify)
+	}
+	newSessionAttempts = configuration.NewSessionAttempts
+	noVncUrl = configuration.NoVncUrl
+	drivers := driver.Start(seleniumHub, configuration.Drivers)
+	if configuration.Recorder != nil {
+		commandRecorder = recorder.New(configuration.Recorder.MaxBodySize, configuration.Recorder.Retention.Duration,
+			seleniumHub.IsActive)
+	}
+
 	router := mux.NewRouter()
 	router.HandleFunc("/grid/register", httpRegisterProxy).Methods("POST")
 	router.HandleFunc("/grid/api/proxy", httpApiProxy).Methods("GET").Queries("id", "")
+	router.HandleFunc("/grid/api/events", httpEvents).Methods("GET")
+	router.HandleFunc("/grid/api/quotas", httpQuotas).Methods("GET")
+	router.HandleFunc("/grid/api/queue", httpQueue).Methods("GET")
+	router.HandleFunc("/grid/api/nodes", httpNodes).Methods("GET")
+	router.HandleFunc("/grid/api/node/drain", httpDrainNode).Methods("POST").Queries("id", "")
+	router.Handle("/debug/vars", expvar.Handler()).Methods("GET")
+	router.HandleFunc("/grid/vnc/{session:[a-f0-9-]+}", httpVnc).Methods("GET")
+	router.HandleFunc("/grid/vnc/{session:[a-f0-9-]+}/view", httpVncViewer).Methods("GET")
+	router.HandleFunc("/grid/api/sessions/{session:[a-f0-9-]+}/commands", httpSessionCommands).Methods("GET")
+	router.HandleFunc("/grid/api/sessions/{session:[a-f0-9-]+}/screenshot", httpSessionScreenshot).Methods("GET")
+	router.HandleFunc("/grid/api/sessions/{session:[a-f0-9-]+}/artifacts", httpSessionArtifacts).Methods("GET")
 	router.HandleFunc("/wd/hub/status", httpStatus).Methods("GET")
 	router.HandleFunc("/wd/hub/sessions", httpGetSessions).Methods("GET")
 	router.HandleFunc("/wd/hub/session", httpCreateSession).Methods("POST")
@@ -39,73 +110,233 @@ func main() {
 	registerWindowRoutes(sessionRouter)
 	registerTouchRoutes(sessionRouter)
 	registerSessionRoutes(sessionRouter)
+	sessionRouter.HandleFunc("/se/bidi", httpWebSocket("bidi")).Methods("GET")
+	sessionRouter.HandleFunc("/se/cdp", httpWebSocket("cdp")).Methods("GET")
 
-	http.Handle("/", router)
+	handler, err := protectRoutes(configuration, router)
+	if err != nil {
+		log.Error("Could not configure authentication", "error", err)
+		os.Exit(1)
+	}
 	server := &http.Server{
-		Addr:           ":4444",
-		Handler:        nil,
+		Addr:           configuration.Address,
+		Handler:        withRequestId(limitBody(configuration.MaxBodySize, routePrefix(configuration.BasePath, handler))),
 		ReadTimeout:    15*time.Minute,
 		WriteTimeout:   15*time.Minute,
 		MaxHeaderBytes: 1<<20,
 	}
-	server.ListenAndServe()
+	if configuration.TLS != nil {
+		if server.TLSConfig, err = newServerTLS(configuration.TLS); err != nil {
+			log.Error("Invalid TLS configuration", "error", err)
+			os.Exit(1)
+		}
+	}
+	stopped := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		received := <-signals
+		// All shutdown messages share one request id.
+		shutdown := logger.WithRequestId(context.Background(), logger.NewRequestId())
+		log.InfoContext(shutdown, "Received signal, shutdown", "signal", received.String())
+		go func() {
+			// The second signal stops the hub without waiting for sessions.
+			log.WarnContext(shutdown, "Received signal during shutdown, exit", "signal", (<-signals).String())
+			os.Exit(1)
+		}()
+		seleniumHub.Shutdown(shutdown, configuration.ShutdownTimeout.Duration)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			server.Close()
+		}
+		close(stopped)
+	}()
+	if server.TLSConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		// Provisioned nodes and drivers are started before the server, so they are stopped here too.
+		shutdown := logger.WithRequestId(context.Background(), logger.NewRequestId())
+		log.ErrorContext(shutdown, "Server stopped", "error", err)
+		seleniumHub.Shutdown(shutdown, configuration.ShutdownTimeout.Duration)
+		drivers.Stop()
+		os.Exit(1)
+	}
+	<-stopped
+	drivers.Stop()
 }
 
 func httpCreateSession(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html;charset=UTF-8")
-	log.Println("Received a create new sessions request")
+	log.InfoContext(r.Context(), "Received a create new session request")
 	var buffer bytes.Buffer
-	buffer.ReadFrom(r.Body)
+	if _, err := buffer.ReadFrom(r.Body); err != nil {
+		log.WarnContext(r.Context(), "Could not read new session request", "error", err)
+		if isBodyTooLarge(err) {
+			bodyTooLarge(w)
+		} else {
+			http.Error(w, "Could not read request.", http.StatusBadRequest)
+		}
+		return
+	}
 	capabilities, err := translator.GetCreateSessionCapabilities(buffer.Bytes())
 	if err != nil {
+		log.WarnContext(r.Context(), "Invalid capabilities", "error", err)
 		http.Error(w, "Invalid capabilities.", http.StatusMethodNotAllowed)
 		return
 	}
-	if seleniumSession, reserved := seleniumHub.ReserveSession(capabilities); reserved {
-		if seleniumSession.Status == session.Prestarted {
-			seleniumHub.StartSession(seleniumSession)
-			setHttpHeaders(w)
-			w.Write(translator.GetCreateSessionAnswerData(seleniumSession))
+	if seleniumHub.IsDraining() {
+		answer := answer{}
+		answer.Message = "Hub is shutting down and does not accept new sessions."
+		answer.LocalizedMessage = "Хаб останавливается и не принимает новые сессии."
+		response(w, 13, answer)
+		return
+	}
+	var failed []*session.Node
+	var failure func()
+	for attempt := 1; ; attempt++ {
+		seleniumSession, err := seleniumHub.ReserveSession(r.Context(), capabilities, failed...)
+		if err != nil {
+			if failure != nil {
+				log.WarnContext(r.Context(), "No other node to retry new session", "capabilities", capabilities,
+					"attempts", len(failed), "error", err)
+				failure()
+			} else if err == hub.ErrNoSlot {
+				answer := answer{}
+				answer.Message = "Session for required capabilities was not found."
+				answer.LocalizedMessage = "Сессия, подходящая под запрашиваемые требования не найдена."
+				response(w, 13, answer)
+			} else {
+				answer := answer{}
+				answer.Message = err.Error()
+				answer.LocalizedMessage = err.Error()
+				response(w, 13, answer)
+			}
 			return
-		} else {
-			data, status, error := proxy.ProxyRequest(seleniumSession.Node.Url, r, bytes.NewReader(buffer.Bytes()))
-			if error != nil {
+		}
+		seleniumSession.Owner = auth.Identity(r.Context())
+		if seleniumSession.Status == session.Prestarted {
+			// A prestarted browser has no BiDi connection, so it is replaced when the client asks for one.
+			if !translator.WantsWebSocket(buffer.Bytes()) {
+				seleniumHub.StartSession(seleniumSession)
+				setHttpHeaders(w)
+				w.Write(translator.GetCreateSessionAnswerData(seleniumSession))
+				return
+			}
+			seleniumHub.DiscardPrestarted(seleniumSession)
+		}
+		data, status, error := proxy.ProxyRequest(seleniumSession.Node.Endpoint(), r, bytes.NewReader(buffer.Bytes()))
+		if error != nil {
+			log.ErrorContext(r.Context(), "Could not create session", "node", seleniumSession.Node.Url,
+				"capabilities", capabilities, "attempt", attempt, "error", error)
+			failure = func() {
 				answer := answer{}
 				answer.Message = error.Error()
 				answer.LocalizedMessage = error.Error()
 				response(w, 13, answer)
-			} else if status == 200 {
+			}
+		} else {
+			if status == 200 {
 				seleniumSessionAnswer := translator.GetCreateSessionAnswer(data)
 				if seleniumSessionAnswer.Status == 0 {
 					seleniumSession.Id = seleniumSessionAnswer.SessionID
+					data, seleniumSession.WebSockets = translator.RewriteWebSocketUrls(data,
+						webSocketBase(r) + clientPrefix(r) + "/session/" + seleniumSession.Id)
+					log.InfoContext(r.Context(), "Session created", "session", seleniumSession.Id,
+						"node", seleniumSession.Node.Url, "capabilities", capabilities, "owner", seleniumSession.Owner)
 					seleniumHub.StartSession(seleniumSession)
 					setHttpHeaders(w)
 					w.Write(data)
 					return
 				}
 			}
+			if status >= 400 && status < 500 {
+				// The request of the client is wrong, so other nodes would refuse it too.
+				log.WarnContext(r.Context(), "Node rejected new session request", "node", seleniumSession.Node.Url,
+					"capabilities", capabilities, "status", status)
+				seleniumHub.ReleaseSession(r.Context(), seleniumSession)
+				setHttpHeaders(w)
+				w.WriteHeader(status)
+				w.Write(data)
+				return
+			}
+			log.ErrorContext(r.Context(), "Node refused to create session", "node", seleniumSession.Node.Url,
+				"capabilities", capabilities, "attempt", attempt, "status", status)
+			failure = func() {
+				setHttpHeaders(w)
+				w.WriteHeader(status)
+				w.Write(data)
+			}
 		}
-		seleniumSession.Finish()
-	} else {
-		answer := answer{}
-		answer.Message = "Session for required capabilities was not found."
-		answer.LocalizedMessage = "Сессия, подходящая под запрашиваемые требования не найдена."
-		response(w, 13, answer)
+		seleniumHub.CancelSession(r.Context(), seleniumSession)
+		failed = append(failed, seleniumSession.Node)
+		if attempt >= newSessionAttempts {
+			failure()
+			return
+		}
+		log.InfoContext(r.Context(), "Retry new session on another node", "capabilities", capabilities,
+			"attempt", attempt+1)
+	}
+}
+
+// httpWebSocket proxies a BiDi or CDP connection of a session to the node.
+func httpWebSocket(protocol string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sessionId := mux.Vars(r)["session"]
+		target, found := seleniumHub.GetSessionWebSocket(sessionId, protocol)
+		if !found {
+			log.WarnContext(r.Context(), "WebSocket of session not found", "session", sessionId, "protocol", protocol)
+			http.NotFound(w, r)
+			return
+		}
+		log.InfoContext(r.Context(), "Proxy WebSocket", "session", sessionId, "protocol", protocol, "target", target)
+		err := proxy.ProxyWebSocket(w, r, target, func() {
+			seleniumHub.GetSessionUrl(sessionId)
+		})
+		if err != nil {
+			log.ErrorContext(r.Context(), "Could not proxy WebSocket", "session", sessionId, "target", target,
+				"error", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		log.DebugContext(r.Context(), "WebSocket closed", "session", sessionId, "protocol", protocol)
+	}
+}
+
+// webSocketBase returns ws:// or wss:// URL of the hub as the client sees it.
+func webSocketBase(r *http.Request) string {
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		return "wss://" + r.Host
 	}
+	return "ws://" + r.Host
 }
 
 func httpFreeSession(w http.ResponseWriter, r *http.Request) {
 	sessionId := mux.Vars(r)["session"]
+	seleniumHub.CaptureScreenshot(r.Context(), sessionId)
 	proxySessionRequest(w, r)
-	seleniumHub.FreeSession(sessionId)
+	seleniumHub.FreeSession(r.Context(), sessionId)
 }
 
 func httpRegisterProxy(w http.ResponseWriter, r *http.Request) {
-	log.Println("Received a register new selenium node request")
+	log.InfoContext(r.Context(), "Received a register new selenium node request")
 	setHttpHeaders(w)
 	w.Header().Set("Content-Type", "text/html;charset=UTF-8")
-	machine, _ := translator.GetProxy(r.Body)
-	if registered := seleniumHub.RegisterNode(machine); registered {
+	machine, err := translator.GetProxy(r.Body)
+	if err != nil {
+		log.WarnContext(r.Context(), "Invalid registration request", "error", err)
+		http.Error(w, "Invalid registration request.", http.StatusBadRequest)
+		return
+	}
+	if nodeRegistration != nil && !nodeRegistration.Allow(r, machine.Configuration.RegistrationSecret) {
+		http.Error(w, "Node is not allowed to register.", http.StatusForbidden)
+		return
+	}
+	machine.Configuration.RegistrationSecret = ""
+	if registered := seleniumHub.RegisterNode(r.Context(), machine); registered {
 		w.Write([]byte("ok"))
 	} else {
 		answer := answer{}
@@ -136,7 +367,6 @@ func httpApiProxy(w http.ResponseWriter, r *http.Request) {
 }
 
 func httpStatus(w http.ResponseWriter, r *http.Request) {
-	log.Println("DD")
 	type os struct {
 		Name string `json:"name"`
 		Arch string `json:"arch"`
@@ -147,6 +377,71 @@ func httpStatus(w http.ResponseWriter, r *http.Request) {
 	response(w, 0, value{os{runtime.GOOS, runtime.GOARCH}})
 }
 
+func httpSessionCommands(w http.ResponseWriter, r *http.Request) {
+	sessionId := mux.Vars(r)["session"]
+	if commandRecorder == nil {
+		http.Error(w, "Command recorder is disabled.", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.FormValue("format") == "jsonl" {
+		if _, found := commandRecorder.Commands(sessionId); !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.jsonl", sessionId))
+		commandRecorder.Export(sessionId, w)
+		return
+	}
+	if commands, found := commandRecorder.Commands(sessionId); found {
+		response(w, 0, commands)
+	} else {
+		http.NotFound(w, r)
+	}
+}
+
+func httpSessionScreenshot(w http.ResponseWriter, r *http.Request) {
+	if path, found := seleniumHub.GetScreenshot(mux.Vars(r)["session"]); found {
+		w.Header().Set("Content-Type", "image/png")
+		http.ServeFile(w, r, path)
+	} else {
+		http.NotFound(w, r)
+	}
+}
+
+func httpSessionArtifacts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	if artifacts, found := seleniumHub.GetArtifacts(mux.Vars(r)["session"]); found {
+		response(w, 0, artifacts)
+	} else {
+		http.NotFound(w, r)
+	}
+}
+
+func httpQuotas(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	response(w, 0, seleniumHub.GetQuotas())
+}
+
+func httpQueue(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	response(w, 0, seleniumHub.GetQueue())
+}
+
+func httpNodes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	response(w, 0, seleniumHub.GetNodes())
+}
+
+func httpDrainNode(w http.ResponseWriter, r *http.Request) {
+	if seleniumHub.DrainNode(r.FormValue("id")) {
+		response(w, 0, nil)
+	} else {
+		http.NotFound(w, r)
+	}
+}
+
 func response(w http.ResponseWriter, status uint8, value interface {}) {
 	data := translator.GetResponse(status, value)
 	setHttpHeaders(w)
@@ -154,13 +449,45 @@ func response(w http.ResponseWriter, status uint8, value interface {}) {
 }
 
 func proxySessionRequest(w http.ResponseWriter, r *http.Request) {
-	log.Println("Proxy session request:", r.Method, r.URL)
 	sessionId := mux.Vars(r)["session"]
 	setHttpHeaders(w)
 	if url, found := seleniumHub.GetSessionUrl(sessionId); found {
-		data, status, error := proxy.ProxyRequest(url, r, r.Body)
+		log.DebugContext(r.Context(), "Proxy session request", "session", sessionId, "node", url,
+			"method", r.Method, "path", r.URL.Path)
+		var body io.Reader = r.Body
+		var requestBody []byte
+		started := time.Now()
+		// Uploaded files are streamed to the node, so they are recorded without a body.
+		if commandRecorder != nil && !isUpload(r.URL.Path) {
+			var err error
+			if requestBody, err = ioutil.ReadAll(r.Body); err != nil {
+				log.WarnContext(r.Context(), "Could not read session request", "session", sessionId,
+					"method", r.Method, "path", r.URL.Path, "error", err)
+				if isBodyTooLarge(err) {
+					bodyTooLarge(w)
+				} else {
+					http.Error(w, "Could not read request.", http.StatusBadRequest)
+				}
+				return
+			}
+			body = bytes.NewReader(requestBody)
+		}
+		data, status, error := proxy.ProxyRequest(url, r, body)
+		if commandRecorder != nil {
+			commandRecorder.Record(sessionId, started, r.Method, r.URL.Path, requestBody, status, data, error)
+		}
+		if isBodyTooLarge(error) {
+			log.WarnContext(r.Context(), "Request body is too large", "session", sessionId, "method", r.Method,
+				"path", r.URL.Path)
+			bodyTooLarge(w)
+			return
+		}
+		// WebDriver answers 500 to ordinary command errors, so only gateway errors mean a broken node.
+		seleniumHub.RecordNodeRequest(sessionId, error != nil || status == http.StatusBadGateway ||
+			status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout)
 		if error != nil {
-			log.Fatalln("Error while proxy request: %s\n", error)
+			log.ErrorContext(r.Context(), "Error while proxy request", "session", sessionId, "node", url,
+				"method", r.Method, "path", r.URL.Path, "error", error)
 			answer := answer{}
 			answer.Message = error.Error()
 			answer.LocalizedMessage = error.Error()
@@ -170,7 +497,8 @@ func proxySessionRequest(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(status)
 		w.Write(data)
 	} else {
-		log.Println("Session", sessionId, "not found")
+		log.WarnContext(r.Context(), "Session not found", "session", sessionId, "method", r.Method,
+			"path", r.URL.Path)
 		answer := answer{}
 		answer.Message = fmt.Sprintf("Session %s not found.", sessionId)
 		answer.LocalizedMessage = fmt.Sprintf("Сессия %s не найдена.", sessionId)
@@ -182,3 +510,16 @@ func setHttpHeaders(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
 	w.Header().Set("Server", "go-selenium-hub")
 }
+
+// withRequestId attaches a request id to the request context and the response, so log lines
+// of one request can be correlated. Id sent by a client in X-Request-Id is reused.
+func withRequestId(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get("X-Request-Id")
+		if requestId == "" {
+			requestId = logger.NewRequestId()
+		}
+		w.Header().Set("X-Request-Id", requestId)
+		handler.ServeHTTP(w, r.WithContext(logger.WithRequestId(r.Context(), requestId)))
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"selenium-hub/proxy"
+)
+
+type prefixKey struct{}
+
+// routePrefix serves all endpoints under basePath as well and WebDriver API at the root as well as at /wd/hub.
+// A request path is rewritten to the /wd/hub form before routing. The prefix which the client used is kept
+// for URLs which the hub returns to the client.
+func routePrefix(basePath string, handler http.Handler) http.Handler {
+	basePath = strings.TrimRight(basePath, "/")
+	if basePath != "" && !strings.HasPrefix(basePath, "/") {
+		basePath = "/" + basePath
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, requestURI, rawPath := r.URL.Path, r.RequestURI, r.URL.RawPath
+		var prefix string
+		if basePath != "" && (path == basePath || strings.HasPrefix(path, basePath+"/")) {
+			prefix = basePath
+			path = strings.TrimPrefix(path, basePath)
+			requestURI = strings.TrimPrefix(requestURI, basePath)
+			rawPath = strings.TrimPrefix(rawPath, basePath)
+		}
+		if isWebDriverPath(path) {
+			path = proxy.Prefix + path
+			requestURI = proxy.Prefix + requestURI
+			if rawPath != "" {
+				rawPath = proxy.Prefix + rawPath
+			}
+		} else {
+			prefix += proxy.Prefix
+		}
+		r = r.WithContext(context.WithValue(r.Context(), prefixKey{}, prefix))
+		if path != r.URL.Path {
+			url := *r.URL
+			url.Path, url.RawPath = path, rawPath
+			r.URL = &url
+			r.RequestURI = requestURI
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// isWebDriverPath reports whether the path is WebDriver API at the root.
+func isWebDriverPath(path string) bool {
+	return path == "/status" || path == "/sessions" || path == "/session" || strings.HasPrefix(path, "/session/")
+}
+
+// clientPrefix returns the prefix of WebDriver API which the client used, e.g. /wd/hub.
+func clientPrefix(r *http.Request) string {
+	if prefix, found := r.Context().Value(prefixKey{}).(string); found {
+		return prefix
+	}
+	return proxy.Prefix
+}
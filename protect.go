@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"selenium-hub/auth"
+	"selenium-hub/config"
+)
+
+// nodeRegistration is nil when nodes may register without credentials.
+var nodeRegistration *auth.NodeRegistration
+
+// protectRoutes wraps the router with authentication of clients and administrators
+// and configures authentication of node registration.
+func protectRoutes(configuration *config.Config, handler http.Handler) (http.Handler, error) {
+	if configuration.Registration != nil {
+		nodeRegistration = auth.NewNodeRegistration(configuration.Registration.Secret,
+			configuration.Registration.ClientCertificate)
+	}
+	var client, admin http.Handler
+	if configuration.ClientAuth != nil {
+		authenticator, err := newClientAuthenticator(configuration.ClientAuth)
+		if err != nil {
+			return nil, err
+		}
+		client = auth.Middleware(auth.Client, authenticator, handler)
+	}
+	if configuration.AdminToken != "" {
+		admin = auth.Middleware(auth.Admin, auth.Tokens{configuration.AdminToken: "admin"}, handler)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case client != nil && isClientRoute(r.URL.Path):
+			client.ServeHTTP(w, r)
+		case admin != nil && isAdminRoute(r.URL.Path):
+			admin.ServeHTTP(w, r)
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	}), nil
+}
+
+func newClientAuthenticator(configuration *config.ClientAuth) (auth.Authenticator, error) {
+	var chain auth.Chain
+	if configuration.CredentialsFile != "" {
+		basic, err := auth.LoadBasic(configuration.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, basic)
+	}
+	if len(configuration.Tokens) > 0 {
+		chain = append(chain, auth.Tokens(configuration.Tokens))
+	}
+	return chain, nil
+}
+
+// isClientRoute reports whether the path belongs to WebDriver API. The status request stays public.
+func isClientRoute(path string) bool {
+	return strings.HasPrefix(path, "/wd/hub/") && path != "/wd/hub/status"
+}
+
+// isAdminRoute reports whether the path is a management endpoint. Nodes use /grid/api/proxy for heartbeats.
+func isAdminRoute(path string) bool {
+	return (strings.HasPrefix(path, "/grid/api/") && path != "/grid/api/proxy") || strings.HasPrefix(path, "/debug/") ||
+		strings.HasPrefix(path, "/grid/vnc/")
+}
@@ -0,0 +1,212 @@
+package provisioner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"selenium-hub/config"
+	"selenium-hub/session"
+	"selenium-hub/translator"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dockerLabel = "selenium-hub"
+
+// Docker starts browser containers through the Docker Engine API and removes them when they are released.
+type Docker struct {
+	configuration *config.Docker
+	client        *http.Client
+	baseUrl       string
+	host          string
+	containers    map[string]string
+	locker        *sync.Mutex
+	limit         *limit
+}
+
+type containerCreated struct {
+	Id string `json:"Id"`
+}
+
+type portBinding struct {
+	HostIp   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+type containerInspect struct {
+	NetworkSettings struct {
+		Ports map[string][]portBinding `json:"Ports"`
+	} `json:"NetworkSettings"`
+}
+
+type dockerError struct {
+	Message string `json:"message"`
+}
+
+func NewDocker(configuration *config.Docker) (*Docker, error) {
+	var docker *Docker = new(Docker)
+	docker.configuration = configuration
+	docker.containers = make(map[string]string)
+	docker.locker = new(sync.Mutex)
+	docker.limit = &limit{max: configuration.MaxNodes}
+	endpoint, err := url.Parse(configuration.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	transport := new(http.Transport)
+	switch endpoint.Scheme {
+	case "unix":
+		socket := endpoint.Path
+		transport.Dial = func(network, addr string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		}
+		docker.baseUrl = "http://docker"
+		docker.host = "localhost"
+	case "tcp", "http":
+		docker.baseUrl = "http://" + endpoint.Host
+		docker.host = endpoint.Hostname()
+	default:
+		return nil, fmt.Errorf("unsupported docker endpoint %s", configuration.Endpoint)
+	}
+	if configuration.Host != "" {
+		docker.host = configuration.Host
+	}
+	docker.client = &http.Client{Transport: transport, Timeout: time.Minute}
+	return docker, nil
+}
+
+// Provision starts a container from the first image which satisfies capabilities and waits
+// until WebDriver in it is ready. It returns a registration request for a node with a single slot.
+func (docker *Docker) Provision(capabilities *session.Capabilities) (*translator.Proxy, error) {
+	var image *config.DockerImage
+	for _, candidate := range docker.configuration.Images {
+		if match(&candidate.Capabilities, candidate.Labels, capabilities) {
+			image = candidate
+			break
+		}
+	}
+	if image == nil {
+		return nil, ErrUnsupported
+	}
+	if !docker.limit.acquire() {
+		return nil, ErrNoCapacity
+	}
+	machine, err := docker.start(image)
+	if err != nil {
+		docker.limit.release()
+	}
+	return machine, err
+}
+
+func (docker *Docker) start(image *config.DockerImage) (*translator.Proxy, error) {
+	log.Info("Start container", "image", image.Image, "labels", image.Labels)
+	containerPort := fmt.Sprintf("%d/tcp", image.Port)
+	containerLabels := map[string]string{dockerLabel: "true"}
+	for key, value := range image.Labels {
+		containerLabels[dockerLabel+"."+key] = value
+	}
+	request := map[string]interface{}{
+		"Image":        image.Image,
+		"Labels":       containerLabels,
+		"ExposedPorts": map[string]interface{}{containerPort: struct{}{}},
+		"HostConfig": map[string]interface{}{
+			"PortBindings": map[string][]portBinding{containerPort: {{}}},
+		},
+	}
+	created := containerCreated{}
+	if err := docker.call("POST", "/containers/create", request, &created); err != nil {
+		return nil, err
+	}
+	if err := docker.call("POST", "/containers/"+created.Id+"/start", nil, nil); err != nil {
+		docker.remove(created.Id)
+		return nil, err
+	}
+	inspect := containerInspect{}
+	if err := docker.call("GET", "/containers/"+created.Id+"/json", nil, &inspect); err != nil {
+		docker.remove(created.Id)
+		return nil, err
+	}
+	bindings := inspect.NetworkSettings.Ports[containerPort]
+	if len(bindings) == 0 {
+		docker.remove(created.Id)
+		return nil, fmt.Errorf("port %s of container %s is not published", containerPort, created.Id)
+	}
+	nodeUrl := fmt.Sprintf("http://%s:%s", docker.host, bindings[0].HostPort)
+	machine := translator.NewSingleSlotProxy(nodeUrl, image.Capabilities)
+	machine.Configuration.BasePath = image.BasePath
+	machine.Configuration.Labels = image.Labels
+	if !WaitReady(machine.Endpoint(), docker.configuration.StartTimeout.Duration) {
+		docker.remove(created.Id)
+		return nil, fmt.Errorf("container %s was not ready in %s", created.Id, docker.configuration.StartTimeout)
+	}
+	docker.locker.Lock()
+	docker.containers[nodeUrl] = created.Id
+	docker.locker.Unlock()
+	log.Info("Container is ready", "container", created.Id, "node", nodeUrl)
+	return machine, nil
+}
+
+// Release removes a container which served the node.
+func (docker *Docker) Release(node *session.Node) {
+	docker.locker.Lock()
+	containerId, found := docker.containers[node.Url]
+	delete(docker.containers, node.Url)
+	docker.locker.Unlock()
+	if found {
+		docker.remove(containerId)
+		docker.limit.release()
+	}
+}
+
+// Capacity returns how many containers may be started right now.
+func (docker *Docker) Capacity() int {
+	return docker.limit.capacity()
+}
+
+func (docker *Docker) remove(containerId string) {
+	log.Info("Remove container", "container", containerId)
+	if err := docker.call("DELETE", "/containers/"+containerId+"?force=true&v=true", nil, nil); err != nil {
+		log.Error("Could not remove container", "container", containerId, "error", err)
+	}
+}
+
+func (docker *Docker) call(method, path string, body interface{}, answer interface{}) error {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	request, err := http.NewRequest(method, docker.baseUrl+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := docker.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	data, err = ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= 300 {
+		message := dockerError{}
+		json.Unmarshal(data, &message)
+		if message.Message == "" {
+			message.Message = strings.TrimSpace(string(data))
+		}
+		return errors.New(method + " " + path + ": " + message.Message)
+	}
+	if answer != nil {
+		return json.Unmarshal(data, answer)
+	}
+	return nil
+}
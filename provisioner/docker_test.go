@@ -0,0 +1,207 @@
+package provisioner
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"selenium-hub/config"
+	"selenium-hub/session"
+)
+
+// fakeDocker serves the part of Docker Engine API which Docker provisioner uses.
+type fakeDocker struct {
+	server    *httptest.Server
+	hostPort  string
+	failStart bool
+	locker    sync.Mutex
+	created   int
+	started   []string
+	removed   []string
+}
+
+func newFakeDocker(t *testing.T, nodePort string) *fakeDocker {
+	docker := &fakeDocker{hostPort: nodePort}
+	docker.server = httptest.NewServer(http.HandlerFunc(docker.serve))
+	t.Cleanup(docker.server.Close)
+	return docker
+}
+
+func (docker *fakeDocker) serve(w http.ResponseWriter, r *http.Request) {
+	docker.locker.Lock()
+	defer docker.locker.Unlock()
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/containers/create":
+		docker.created++
+		json.NewEncoder(w).Encode(containerCreated{Id: "container" + strconv.Itoa(docker.created)})
+	case r.Method == "POST" && len(path) == 3 && path[2] == "start":
+		if docker.failStart {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(dockerError{Message: "start failed"})
+			return
+		}
+		docker.started = append(docker.started, path[1])
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "GET" && len(path) == 3 && path[2] == "json":
+		inspect := containerInspect{}
+		inspect.NetworkSettings.Ports = map[string][]portBinding{
+			"4444/tcp": {{HostIp: "0.0.0.0", HostPort: docker.hostPort}},
+		}
+		json.NewEncoder(w).Encode(inspect)
+	case r.Method == "DELETE" && len(path) == 2:
+		docker.removed = append(docker.removed, path[1])
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (docker *fakeDocker) state() (created int, started []string, removed []string) {
+	docker.locker.Lock()
+	defer docker.locker.Unlock()
+	return docker.created, append([]string(nil), docker.started...), append([]string(nil), docker.removed...)
+}
+
+// newFakeNode serves WebDriver status as a browser container would do.
+func newFakeNode(t *testing.T, status int) string {
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/wd/hub/status" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(node.Close)
+	address, _ := url.Parse(node.URL)
+	return address.Port()
+}
+
+func newTestDocker(t *testing.T, docker *fakeDocker, maxNodes int) *Docker {
+	configuration := &config.Docker{
+		Endpoint: strings.Replace(docker.server.URL, "http://", "tcp://", 1),
+		MaxNodes: maxNodes,
+		Images: []*config.DockerImage{
+			{Capabilities: session.Capabilities{BrowserName: "chrome"}, Image: "selenium/standalone-chrome", Port: 4444},
+		},
+	}
+	configuration.StartTimeout.Duration = 2 * time.Second
+	provisioner, err := NewDocker(configuration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provisioner
+}
+
+func TestDockerProvisionAndRelease(t *testing.T) {
+	nodePort := newFakeNode(t, http.StatusOK)
+	fake := newFakeDocker(t, nodePort)
+	docker := newTestDocker(t, fake, 1)
+
+	machine, err := docker.Provision(&session.Capabilities{BrowserName: "chrome"})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if want := "http://127.0.0.1:" + nodePort; machine.Configuration.Url != want {
+		t.Errorf("node URL = %s, want %s", machine.Configuration.Url, want)
+	}
+	if machine.Configuration.MaxSession != 1 || len(machine.Capabilities) != 1 {
+		t.Errorf("provisioned node must have a single slot, got %d sessions", machine.Configuration.MaxSession)
+	}
+	if _, started, _ := fake.state(); len(started) != 1 {
+		t.Fatalf("started containers = %v, want one", started)
+	}
+	if capacity := docker.Capacity(); capacity != 0 {
+		t.Errorf("Capacity = %d, want 0", capacity)
+	}
+	if _, err := docker.Provision(&session.Capabilities{BrowserName: "chrome"}); err != ErrNoCapacity {
+		t.Errorf("Provision over the limit: %v, want ErrNoCapacity", err)
+	}
+
+	docker.Release(session.NewNode(machine.Configuration.Url, 1))
+	if _, started, removed := fake.state(); len(removed) != 1 || removed[0] != started[0] {
+		t.Errorf("removed containers = %v, want %v", removed, started)
+	}
+	if capacity := docker.Capacity(); capacity != 1 {
+		t.Errorf("Capacity after release = %d, want 1", capacity)
+	}
+	docker.Release(session.NewNode(machine.Configuration.Url, 1))
+	if _, _, removed := fake.state(); len(removed) != 1 {
+		t.Errorf("second release removed containers %v", removed)
+	}
+}
+
+func TestDockerProvisionUnsupported(t *testing.T) {
+	fake := newFakeDocker(t, newFakeNode(t, http.StatusOK))
+	docker := newTestDocker(t, fake, 1)
+
+	if _, err := docker.Provision(&session.Capabilities{BrowserName: "firefox"}); err != ErrUnsupported {
+		t.Errorf("Provision: %v, want ErrUnsupported", err)
+	}
+	if created, _, _ := fake.state(); created != 0 {
+		t.Errorf("created containers = %d, want 0", created)
+	}
+}
+
+func TestDockerProvisionRemovesFailedContainers(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		failStart bool
+	}{
+		{"start fails", http.StatusOK, true},
+		{"WebDriver is not ready", http.StatusInternalServerError, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake := newFakeDocker(t, newFakeNode(t, test.status))
+			fake.failStart = test.failStart
+			docker := newTestDocker(t, fake, 1)
+
+			if _, err := docker.Provision(&session.Capabilities{BrowserName: "chrome"}); err == nil {
+				t.Fatal("Provision succeeded, want error")
+			}
+			if created, _, removed := fake.state(); created != 1 || len(removed) != 1 {
+				t.Errorf("created %d containers, removed %v; want the container removed", created, removed)
+			}
+			if capacity := docker.Capacity(); capacity != 1 {
+				t.Errorf("Capacity = %d, want 1", capacity)
+			}
+		})
+	}
+}
+
+func TestDockerProvisionSelector(t *testing.T) {
+	fake := newFakeDocker(t, newFakeNode(t, http.StatusOK))
+	docker := newTestDocker(t, fake, 1)
+	docker.configuration.Images[0].Labels = map[string]string{"dc": "eu"}
+	selector := func(expression string) *session.NodeSelector {
+		required, err := session.ParseSelector(expression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &session.NodeSelector{Required: required}
+	}
+
+	// Labels come from the image, a request can not give them to a node.
+	if _, err := docker.Provision(&session.Capabilities{BrowserName: "chrome",
+		NodeSelector: selector("gpu=nvidia")}); err != ErrUnsupported {
+		t.Errorf("Provision for labels the image does not have: %v, want ErrUnsupported", err)
+	}
+	if created, _, _ := fake.state(); created != 0 {
+		t.Errorf("created containers = %d, want 0", created)
+	}
+	machine, err := docker.Provision(&session.Capabilities{BrowserName: "chrome", NodeSelector: selector("dc=eu,!legacy")})
+	if err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if machine.Configuration.Labels["dc"] != "eu" || len(machine.Configuration.Labels) != 1 {
+		t.Errorf("node labels = %v, want labels of the image", machine.Configuration.Labels)
+	}
+}
@@ -0,0 +1,105 @@
+package provisioner
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"selenium-hub/config"
+	"selenium-hub/session"
+	"selenium-hub/translator"
+)
+
+// Exec runs a local command, e.g. chromedriver, on a free port for every session.
+type Exec struct {
+	configuration *config.Exec
+	processes     map[string]*exec.Cmd
+	locker        *sync.Mutex
+	limit         *limit
+}
+
+func NewExec(configuration *config.Exec) *Exec {
+	var command *Exec = new(Exec)
+	command.configuration = configuration
+	command.processes = make(map[string]*exec.Cmd)
+	command.locker = new(sync.Mutex)
+	command.limit = &limit{max: configuration.MaxNodes}
+	return command
+}
+
+func (command *Exec) Provision(capabilities *session.Capabilities) (*translator.Proxy, error) {
+	if !match(&command.configuration.Capabilities, command.configuration.Labels, capabilities) {
+		return nil, ErrUnsupported
+	}
+	if !command.limit.acquire() {
+		return nil, ErrNoCapacity
+	}
+	machine, err := command.start()
+	if err != nil {
+		command.limit.release()
+	}
+	return machine, err
+}
+
+func (command *Exec) start() (*translator.Proxy, error) {
+	port, err := FreePort()
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	for _, arg := range command.configuration.Args {
+		args = append(args, strings.Replace(arg, "{port}", strconv.Itoa(port), -1))
+	}
+	process := exec.Command(command.configuration.Command, args...)
+	process.Stdout = os.Stdout
+	process.Stderr = os.Stderr
+	log.Info("Start command", "command", command.configuration.Command, "args", args)
+	if err = process.Start(); err != nil {
+		return nil, err
+	}
+	nodeUrl := fmt.Sprintf("http://127.0.0.1:%d", port)
+	machine := translator.NewSingleSlotProxy(nodeUrl, command.configuration.Capabilities)
+	machine.Configuration.BasePath = command.configuration.BasePath
+	machine.Configuration.Labels = command.configuration.Labels
+	if !WaitReady(machine.Endpoint(), command.configuration.StartTimeout.Duration) {
+		process.Process.Kill()
+		process.Wait()
+		return nil, fmt.Errorf("%s was not ready in %s", command.configuration.Command, command.configuration.StartTimeout)
+	}
+	command.locker.Lock()
+	command.processes[nodeUrl] = process
+	command.locker.Unlock()
+	return machine, nil
+}
+
+// Release kills a process which served the node.
+func (command *Exec) Release(node *session.Node) {
+	command.locker.Lock()
+	process, found := command.processes[node.Url]
+	delete(command.processes, node.Url)
+	command.locker.Unlock()
+	if found {
+		log.Info("Stop command", "command", command.configuration.Command, "node", node.Url)
+		process.Process.Kill()
+		process.Wait()
+		command.limit.release()
+	}
+}
+
+func (command *Exec) Capacity() int {
+	return command.limit.capacity()
+}
+
+// FreePort asks the system for a TCP port which is not in use.
+func FreePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
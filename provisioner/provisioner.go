@@ -0,0 +1,82 @@
+package provisioner
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"selenium-hub/logger"
+	"selenium-hub/proxy"
+	"selenium-hub/session"
+)
+
+var log = logger.For("provisioner")
+
+// ErrUnsupported is returned by Provision when a provisioner cannot serve capabilities.
+var ErrUnsupported = errors.New("capabilities are not supported by provisioner")
+
+// ErrNoCapacity is returned by Provision when a provisioner has already started as many nodes as it may.
+var ErrNoCapacity = errors.New("provisioner has no free capacity")
+
+// match reports whether a slot of a node with labels satisfies desired capabilities.
+// Preferred labels of hub:nodeSelector are left to the hub, only required ones must match.
+func match(slot *session.Capabilities, labels map[string]string, desired *session.Capabilities) bool {
+	if desired.NodeSelector != nil && !desired.NodeSelector.Required.Matches(labels) {
+		return false
+	}
+	if slot.BrowserName != desired.BrowserName {
+		return false
+	}
+	if !desired.Version.Any() && !slot.Version.Any() && desired.Version != slot.Version {
+		return false
+	}
+	if !desired.Platform.Any() && !slot.Platform.Any() && desired.Platform != slot.Platform {
+		return false
+	}
+	return true
+}
+
+// limit counts nodes which are started by a provisioner at the same time.
+type limit struct {
+	max    int
+	used   int
+	locker sync.Mutex
+}
+
+func (limit *limit) acquire() bool {
+	limit.locker.Lock()
+	defer limit.locker.Unlock()
+	if limit.max > 0 && limit.used >= limit.max {
+		return false
+	}
+	limit.used++
+	return true
+}
+
+func (limit *limit) release() {
+	limit.locker.Lock()
+	defer limit.locker.Unlock()
+	limit.used--
+}
+
+func (limit *limit) capacity() int {
+	limit.locker.Lock()
+	defer limit.locker.Unlock()
+	if limit.max <= 0 {
+		return math.MaxInt32
+	}
+	return limit.max - limit.used
+}
+
+// WaitReady polls WebDriver status on the URL of WebDriver API until it answers or timeout expires.
+func WaitReady(nodeUrl string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if proxy.Ping(nodeUrl) {
+			return true
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	return false
+}
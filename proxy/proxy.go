@@ -5,32 +5,84 @@
 package proxy
 
 import (
+	"context"
+	"crypto/tls"
 	"net/http"
 	"io"
 	"net"
 	"io/ioutil"
+	"strings"
 	"time"
 )
 
 const browserTimeout = 30*time.Second
 
-func ProxyRequest(url string, r *http.Request, body io.Reader) (data []byte, status int, err error) {
-	var client *http.Client = new(http.Client)
+// maxIdlePerNode is how many idle connections to every node are kept for reuse.
+const maxIdlePerNode = 16
+
+// Prefix is where the hub serves WebDriver API. It is replaced by the URL of WebDriver API of a node.
+const Prefix = "/wd/hub"
+
+// tlsConfig is used for https:// nodes.
+var tlsConfig *tls.Config
+
+// client and pinger share one transport, so connections to nodes are reused between requests.
+var (
+	client *http.Client
+	pinger *http.Client
+)
+
+func init() {
+	Configure(nil)
+}
+
+// Configure sets TLS configuration for connections to https:// nodes: trusted CA and client certificate.
+// It is called once at startup, before requests are proxied.
+func Configure(config *tls.Config) {
+	tlsConfig = config
 	transport := &http.Transport{
 		Dial: func(network, addr string) (net.Conn, error) {
 			return net.DialTimeout(network, addr, browserTimeout)
 		},
+		TLSClientConfig:     config,
+		MaxIdleConnsPerHost: maxIdlePerNode,
+		IdleConnTimeout:     90 * time.Second,
 	}
-	client.Transport = transport
-	request, err := http.NewRequest(r.Method, url + r.RequestURI, body)
+	client = &http.Client{Transport: transport}
+	pinger = &http.Client{Transport: transport, Timeout: browserTimeout}
+}
+
+func ProxyRequest(url string, r *http.Request, body io.Reader) (data []byte, status int, err error) {
+	return ProxyRequestContext(context.Background(), url, r, body)
+}
+
+// ProxyRequestContext is ProxyRequest which is cancelled with ctx, e.g. when the node does not answer in time.
+func ProxyRequestContext(ctx context.Context, url string, r *http.Request, body io.Reader) (data []byte, status int,
+	err error) {
+	request, err := http.NewRequestWithContext(ctx, r.Method, url + strings.TrimPrefix(r.RequestURI, Prefix), body)
 	if err != nil {
 		return
 	}
+	// A streamed body keeps the length announced by the client instead of chunked encoding.
+	if request.ContentLength == 0 && body != nil && r.ContentLength > 0 {
+		request.ContentLength = r.ContentLength
+	}
 	response, err := client.Do(request)
 	if err != nil {
 		return
 	}
+	defer response.Body.Close()
 	status = response.StatusCode
 	data, err = ioutil.ReadAll(response.Body)
 	return
 }
+
+// Ping checks that WebDriver API on the url answers to the status request.
+func Ping(url string) bool {
+	response, err := pinger.Get(url + "/status")
+	if err != nil {
+		return false
+	}
+	response.Body.Close()
+	return response.StatusCode == http.StatusOK
+}
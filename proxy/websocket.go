@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"sync"
+	"time"
+)
+
+// ProxyWebSocket connects the client to the ws:// or wss:// target and copies frames in both directions
+// until one side closes the connection. Activity is called whenever the client sends data.
+func ProxyWebSocket(w http.ResponseWriter, r *http.Request, target string, activity func()) error {
+	address, err := neturl.Parse(target)
+	if err != nil {
+		return err
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return errors.New("connection does not support hijacking")
+	}
+	backend, err := dialWebSocket(address)
+	if err != nil {
+		return err
+	}
+	defer backend.Close()
+	request := r.Clone(r.Context())
+	request.URL = &neturl.URL{Path: address.Path, RawQuery: address.RawQuery}
+	request.Host = address.Host
+	request.RequestURI = ""
+	request.Body = nil
+	request.ContentLength = 0
+	request.Header.Del("Authorization")
+	if err = request.Write(backend); err != nil {
+		return err
+	}
+	backendReader := bufio.NewReader(backend)
+	response, err := http.ReadResponse(backendReader, request)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		defer response.Body.Close()
+		for name, values := range response.Header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(response.StatusCode)
+		io.Copy(w, response.Body)
+		return nil
+	}
+	client, clientBuffer, err := hijacker.Hijack()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	// Deadlines of the HTTP server must not break a long living connection.
+	client.SetDeadline(time.Time{})
+	fmt.Fprintf(clientBuffer, "HTTP/1.1 %s\r\n", response.Status)
+	response.Header.Write(clientBuffer)
+	clientBuffer.WriteString("\r\n")
+	// The client is gone when the handshake can not be written, there is nobody to report the error to.
+	if clientBuffer.Flush() != nil {
+		return nil
+	}
+	var closeOnce sync.Once
+	closeBoth := func() {
+		closeOnce.Do(func() {
+			client.Close()
+			backend.Close()
+		})
+	}
+	go func() {
+		defer closeBoth()
+		io.Copy(client, backendReader)
+	}()
+	defer closeBoth()
+	io.Copy(backend, activityReader{clientBuffer.Reader, activity})
+	return nil
+}
+
+func dialWebSocket(address *neturl.URL) (net.Conn, error) {
+	host := address.Host
+	if address.Port() == "" {
+		if address.Scheme == "wss" {
+			host = net.JoinHostPort(address.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(address.Hostname(), "80")
+		}
+	}
+	dialer := &net.Dialer{Timeout: browserTimeout}
+	if address.Scheme != "wss" {
+		return dialer.Dial("tcp", host)
+	}
+	var config *tls.Config
+	if tlsConfig != nil {
+		config = tlsConfig.Clone()
+	} else {
+		config = new(tls.Config)
+	}
+	if config.ServerName == "" {
+		config.ServerName = address.Hostname()
+	}
+	return tls.DialWithDialer(dialer, "tcp", host, config)
+}
+
+// activityReader calls activity after every successful read.
+type activityReader struct {
+	reader   io.Reader
+	activity func()
+}
+
+func (reader activityReader) Read(data []byte) (int, error) {
+	read, err := reader.reader.Read(data)
+	if read > 0 && reader.activity != nil {
+		reader.activity()
+	}
+	return read, err
+}
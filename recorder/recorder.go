@@ -0,0 +1,133 @@
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Command is a single WebDriver command proxied to a node.
+type Command struct {
+	Time         time.Time `json:"time"`
+	Method       string    `json:"method"`
+	Path         string    `json:"path"`
+	RequestBody  string    `json:"requestBody,omitempty"`
+	Status       int       `json:"status"`
+	ResponseBody string    `json:"responseBody,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	DurationMs   int64     `json:"durationMs"`
+}
+
+type record struct {
+	commands []*Command
+	finished time.Time
+}
+
+// Recorder keeps commands of every session. Records of finished sessions are kept for retention.
+type Recorder struct {
+	records     map[string]*record
+	locker      *sync.RWMutex
+	maxBodySize int
+	retention   time.Duration
+	active      func(sessionId string) bool
+}
+
+// New creates a recorder. Active reports whether a session is still running on the hub.
+func New(maxBodySize int, retention time.Duration, active func(sessionId string) bool) *Recorder {
+	var recorder *Recorder = new(Recorder)
+	recorder.records = make(map[string]*record)
+	recorder.locker = new(sync.RWMutex)
+	recorder.maxBodySize = maxBodySize
+	recorder.retention = retention
+	recorder.active = active
+	go recorder.cleanup()
+	return recorder
+}
+
+// Record stores a command of the session. Bodies longer than maxBodySize are truncated,
+// screenshots are replaced by their size.
+func (recorder *Recorder) Record(sessionId string, started time.Time, method, path string,
+	requestBody []byte, status int, responseBody []byte, err error) {
+	command := &Command{
+		Time:        started,
+		Method:      method,
+		Path:        path,
+		RequestBody: recorder.body(requestBody),
+		Status:      status,
+		DurationMs:  time.Since(started).Nanoseconds() / int64(time.Millisecond),
+	}
+	if isScreenshot(path) && len(responseBody) > 0 {
+		command.ResponseBody = fmt.Sprintf("<screenshot elided, %d bytes>", len(responseBody))
+	} else {
+		command.ResponseBody = recorder.body(responseBody)
+	}
+	if err != nil {
+		command.Error = err.Error()
+	}
+	recorder.locker.Lock()
+	defer recorder.locker.Unlock()
+	sessionRecord, found := recorder.records[sessionId]
+	if !found {
+		sessionRecord = new(record)
+		recorder.records[sessionId] = sessionRecord
+	}
+	sessionRecord.commands = append(sessionRecord.commands, command)
+}
+
+// Commands returns recorded commands of the session.
+func (recorder *Recorder) Commands(sessionId string) ([]*Command, bool) {
+	recorder.locker.RLock()
+	defer recorder.locker.RUnlock()
+	sessionRecord, found := recorder.records[sessionId]
+	if !found {
+		return nil, false
+	}
+	return append([]*Command{}, sessionRecord.commands...), true
+}
+
+// Export writes commands of the session as JSON Lines.
+func (recorder *Recorder) Export(sessionId string, writer io.Writer) bool {
+	commands, found := recorder.Commands(sessionId)
+	if !found {
+		return false
+	}
+	encoder := json.NewEncoder(writer)
+	for _, command := range commands {
+		encoder.Encode(command)
+	}
+	return true
+}
+
+func (recorder *Recorder) body(data []byte) string {
+	if recorder.maxBodySize > 0 && len(data) > recorder.maxBodySize {
+		return string(data[:recorder.maxBodySize]) + fmt.Sprintf("<truncated, %d bytes>", len(data))
+	}
+	return string(data)
+}
+
+func (recorder *Recorder) cleanup() {
+	interval := recorder.retention / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	for now := range time.Tick(interval) {
+		recorder.locker.Lock()
+		for sessionId, sessionRecord := range recorder.records {
+			if sessionRecord.finished.IsZero() {
+				if !recorder.active(sessionId) {
+					sessionRecord.finished = now
+				}
+			} else if now.Sub(sessionRecord.finished) >= recorder.retention {
+				delete(recorder.records, sessionId)
+			}
+		}
+		recorder.locker.Unlock()
+	}
+}
+
+func isScreenshot(path string) bool {
+	return strings.HasSuffix(strings.TrimRight(path, "/"), "/screenshot")
+}
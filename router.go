@@ -5,6 +5,8 @@
 package main
 
 import (
+	"strings"
+
 	"github.com/gorilla/mux"
 )
 
@@ -106,5 +108,12 @@ func registerSessionRoutes(router *mux.Router) {
 	api["/log"] = []string{"POST"}
 	api["/log/types"] = []string{"GET"}
 	api["/application_cache/status"] = []string{"GET"}
+	api["/file"] = []string{"POST"}
+	api["/se/file"] = []string{"POST"}
 	registerRoutes(router, api)
 }
+
+// isUpload reports whether the path uploads a file to a remote browser.
+func isUpload(path string) bool {
+	return strings.HasSuffix(path, "/file")
+}
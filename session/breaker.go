@@ -0,0 +1,206 @@
+package session
+
+import (
+	"sync"
+	"time"
+)
+
+type BreakerState uint8
+
+const (
+	// Closed breaker lets the node take new sessions.
+	Closed BreakerState = iota
+	// Open breaker removes the node from scheduling until the cool-down passes.
+	Open
+	// HalfOpen breaker lets one probe session decide whether the node is healthy again.
+	HalfOpen
+)
+
+func (state BreakerState) String() string {
+	switch state {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// Breaker tracks an error rate of the last requests to a node. A nil breaker is always closed.
+type Breaker struct {
+	locker      *sync.Mutex
+	results     []bool
+	position    int
+	recorded    int
+	failures    int
+	threshold   float64
+	minRequests int
+	coolDown    time.Duration
+	state       BreakerState
+	changed     time.Time
+	probe       time.Time
+	// probeSlot is the slot whose session decides whether a half-open breaker closes.
+	probeSlot   *Session
+}
+
+// BreakerStatus is a snapshot of a breaker.
+type BreakerStatus struct {
+	State     string    `json:"state"`
+	ErrorRate float64   `json:"errorRate"`
+	Requests  int       `json:"requests"`
+	Since     time.Time `json:"since"`
+}
+
+// NewBreaker opens after threshold share of the last window requests failed, but not before minRequests
+// requests were recorded.
+func NewBreaker(window int, threshold float64, minRequests int, coolDown time.Duration) *Breaker {
+	var breaker *Breaker = new(Breaker)
+	if window <= 0 {
+		window = 20
+	}
+	breaker.locker = new(sync.Mutex)
+	breaker.results = make([]bool, window)
+	breaker.threshold = threshold
+	breaker.minRequests = minRequests
+	breaker.coolDown = coolDown
+	breaker.changed = time.Now()
+	return breaker
+}
+
+// Available reports whether the node may take a new session, but does not reserve a probe.
+func (breaker *Breaker) Available() bool {
+	if breaker == nil {
+		return true
+	}
+	breaker.locker.Lock()
+	defer breaker.locker.Unlock()
+	return breaker.available(time.Now())
+}
+
+func (breaker *Breaker) available(now time.Time) bool {
+	switch breaker.state {
+	case Open:
+		return now.Sub(breaker.changed) >= breaker.coolDown
+	case HalfOpen:
+		return now.Sub(breaker.probe) >= breaker.coolDown
+	}
+	return true
+}
+
+// Allow reports whether the slot may be reserved. After the cool-down an open breaker becomes half-open
+// and the slot becomes its single probe. Another probe is allowed if the probe did not report in a cool-down.
+func (breaker *Breaker) Allow(slot *Session) bool {
+	if breaker == nil {
+		return true
+	}
+	breaker.locker.Lock()
+	defer breaker.locker.Unlock()
+	now := time.Now()
+	if !breaker.available(now) {
+		return false
+	}
+	switch breaker.state {
+	case Closed:
+		return true
+	case Open:
+		breaker.setState(HalfOpen, now)
+	}
+	breaker.probe = now
+	breaker.probeSlot = slot
+	return true
+}
+
+// Record adds a result of a proxied request to the node. It returns the new state and true when the state changed.
+// Requests are not counted while the breaker is not closed, so only the probe decides whether the node recovered.
+func (breaker *Breaker) Record(failed bool) (BreakerState, bool) {
+	if breaker == nil {
+		return Closed, false
+	}
+	breaker.locker.Lock()
+	defer breaker.locker.Unlock()
+	if breaker.state != Closed {
+		return breaker.state, false
+	}
+	return breaker.record(failed, time.Now())
+}
+
+// RecordSession adds a result of session creation in the slot. A half-open breaker closes or opens again
+// by the result of its probe slot and ignores other slots.
+func (breaker *Breaker) RecordSession(slot *Session, failed bool) (BreakerState, bool) {
+	if breaker == nil {
+		return Closed, false
+	}
+	breaker.locker.Lock()
+	defer breaker.locker.Unlock()
+	now := time.Now()
+	switch breaker.state {
+	case Open:
+		return Open, false
+	case HalfOpen:
+		if slot != breaker.probeSlot {
+			return HalfOpen, false
+		}
+		if failed {
+			breaker.setState(Open, now)
+		} else {
+			breaker.setState(Closed, now)
+		}
+		return breaker.state, true
+	}
+	return breaker.record(failed, now)
+}
+
+func (breaker *Breaker) record(failed bool, now time.Time) (BreakerState, bool) {
+	if breaker.results[breaker.position] {
+		breaker.failures--
+	}
+	breaker.results[breaker.position] = failed
+	breaker.position = (breaker.position + 1) % len(breaker.results)
+	if failed {
+		breaker.failures++
+	}
+	if breaker.recorded < len(breaker.results) {
+		breaker.recorded++
+	}
+	if breaker.recorded >= breaker.minRequests && breaker.errorRate() >= breaker.threshold {
+		breaker.setState(Open, now)
+		return Open, true
+	}
+	return Closed, false
+}
+
+// Status returns the state of the breaker.
+func (breaker *Breaker) Status() BreakerStatus {
+	if breaker == nil {
+		return BreakerStatus{State: Closed.String()}
+	}
+	breaker.locker.Lock()
+	defer breaker.locker.Unlock()
+	return BreakerStatus{
+		State:     breaker.state.String(),
+		ErrorRate: breaker.errorRate(),
+		Requests:  breaker.recorded,
+		Since:     breaker.changed,
+	}
+}
+
+func (breaker *Breaker) errorRate() float64 {
+	if breaker.recorded == 0 {
+		return 0
+	}
+	return float64(breaker.failures) / float64(breaker.recorded)
+}
+
+// setState switches the breaker. Closing forgets recorded results, so old failures do not open it again.
+func (breaker *Breaker) setState(state BreakerState, now time.Time) {
+	breaker.state = state
+	breaker.changed = now
+	breaker.probe = time.Time{}
+	breaker.probeSlot = nil
+	if state == Closed {
+		for i := range breaker.results {
+			breaker.results[i] = false
+		}
+		breaker.position, breaker.recorded, breaker.failures = 0, 0, 0
+	}
+}
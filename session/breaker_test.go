@@ -0,0 +1,39 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerProbe(t *testing.T) {
+	node := NewNode("http://node:4444", 2)
+	probe, other := &Session{Node: node}, &Session{Node: node}
+	breaker := NewBreaker(2, 0.5, 2, time.Millisecond)
+	breaker.Record(true)
+	if state, changed := breaker.Record(true); state != Open || !changed {
+		t.Fatalf("Record = %v, %v, want open", state, changed)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	// Checking slots does not take the probe, only the reserved slot does.
+	if !breaker.Available() || !breaker.Available() {
+		t.Fatal("cooled down breaker is not available")
+	}
+	if !breaker.Allow(probe) {
+		t.Fatal("probe is not allowed")
+	}
+	if breaker.Available() || breaker.Allow(other) {
+		t.Error("second probe is allowed")
+	}
+
+	// Only the probe session decides whether the node recovered.
+	if state, changed := breaker.Record(false); state != HalfOpen || changed {
+		t.Errorf("Record = %v, %v, want half-open", state, changed)
+	}
+	if state, changed := breaker.RecordSession(other, false); state != HalfOpen || changed {
+		t.Errorf("RecordSession of another slot = %v, %v, want half-open", state, changed)
+	}
+	if state, changed := breaker.RecordSession(probe, false); state != Closed || !changed {
+		t.Errorf("RecordSession of the probe = %v, %v, want closed", state, changed)
+	}
+}
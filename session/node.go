@@ -5,19 +5,93 @@
 package session
 
 import (
+	"strings"
+	"sync"
 	"time"
+	"sync/atomic"
 )
 
+// usage is a sequence of node reservations used by round-robin placement.
+var usage uint64
+
 type Node struct {
 	Url              string
+	// Labels are arbitrary attributes of the node like datacenter, GPU or locale.
+	Labels           map[string]string
+	// VncUrl is a WebSocket endpoint of a VNC server which shows browsers of the node.
+	VncUrl           string
+	// BasePath is a path prefix of WebDriver API of the node. It is empty for the root.
+	BasePath         string
 	ApiProxyResponse []byte
 	maxSessions      uint8
 	Timer            *time.Timer
+	// SingleUse node is removed after its session is finished.
+	SingleUse        bool
+	// Draining node does not accept new sessions.
+	Draining         atomic.Bool
+	// Breaker is nil when the circuit breaker is disabled.
+	Breaker          *Breaker
 	sessions         []*Session
+	// locker guards statuses of node sessions, which are read by other goroutines.
+	locker           sync.RWMutex
+	lastUsed         uint64
+	failures         uint32
+	penalizedUntil   int64
 }
 
 func (node *Node) RegisterSession(session *Session) {
+	node.locker.Lock()
 	node.sessions = append(node.sessions, session)
+	node.locker.Unlock()
+}
+
+// Load returns a share of node sessions which are active.
+func (node *Node) Load() float64 {
+	node.locker.RLock()
+	defer node.locker.RUnlock()
+	var active int
+	for _, session := range node.sessions {
+		if session.Status == Active {
+			active++
+		}
+	}
+	return float64(active) / float64(node.maxSessions)
+}
+
+// MarkUsed remembers that a session was just reserved on the node.
+func (node *Node) MarkUsed() {
+	atomic.StoreUint64(&node.lastUsed, atomic.AddUint64(&usage, 1))
+}
+
+func (node *Node) LastUsed() uint64 {
+	return atomic.LoadUint64(&node.lastUsed)
+}
+
+// Failed counts a session which the node could not create. After threshold failures in a row
+// the node is penalized for the duration. It returns true when the node became penalized.
+func (node *Node) Failed(threshold int, duration time.Duration) bool {
+	failures := atomic.AddUint32(&node.failures, 1)
+	if threshold <= 0 || failures < uint32(threshold) {
+		return false
+	}
+	atomic.StoreUint32(&node.failures, 0)
+	atomic.StoreInt64(&node.penalizedUntil, time.Now().Add(duration).UnixNano())
+	return true
+}
+
+// Succeeded resets failures of the node.
+func (node *Node) Succeeded() {
+	atomic.StoreUint32(&node.failures, 0)
+}
+
+// Penalized returns true while the node is used only if there is no other suitable node.
+func (node *Node) Penalized() bool {
+	return time.Now().UnixNano() < atomic.LoadInt64(&node.penalizedUntil)
+}
+
+// Endpoint returns URL of WebDriver API of the node.
+func (node *Node) Endpoint() string {
+	return strings.TrimRight(node.Url, "/") + node.BasePath
 }
 
 func NewNode(url string, maxSessions uint8) *Node {
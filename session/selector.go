@@ -0,0 +1,178 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	opEquals uint8 = iota
+	opNotEquals
+	opIn
+	opNotIn
+	opExists
+	opNotExists
+)
+
+// requirement is one expression of a label selector, e.g. "dc=eu", "gpu in (nvidia,amd)" or "!legacy".
+type requirement struct {
+	key    string
+	op     uint8
+	values []string
+}
+
+func (r requirement) matches(labels map[string]string) bool {
+	value, found := labels[r.key]
+	switch r.op {
+	case opEquals:
+		return found && value == r.values[0]
+	case opNotEquals:
+		return !found || value != r.values[0]
+	case opIn:
+		return found && contains(r.values, value)
+	case opNotIn:
+		return !found || !contains(r.values, value)
+	case opExists:
+		return found
+	case opNotExists:
+		return !found
+	}
+	return false
+}
+
+// Selector is a comma separated list of requirements which all must match.
+type Selector []requirement
+
+// ParseSelector parses expressions like "dc=eu,gpu in (nvidia,amd),resolution!=800x600,!legacy".
+func ParseSelector(expression string) (Selector, error) {
+	var selector Selector
+	for _, part := range splitRequirements(expression) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		parsed, err := parseRequirement(part)
+		if err != nil {
+			return nil, err
+		}
+		selector = append(selector, parsed)
+	}
+	return selector, nil
+}
+
+// Matches reports whether the labels satisfy all requirements.
+func (selector Selector) Matches(labels map[string]string) bool {
+	return selector.Mismatches(labels) == 0
+}
+
+// Mismatches counts requirements which the labels do not satisfy.
+func (selector Selector) Mismatches(labels map[string]string) int {
+	var mismatches int
+	for _, r := range selector {
+		if !r.matches(labels) {
+			mismatches++
+		}
+	}
+	return mismatches
+}
+
+// NodeSelector is the hub:nodeSelector capability. It is either a string with required
+// expressions or an object {"required": "...", "preferred": "..."}.
+type NodeSelector struct {
+	Required  Selector
+	Preferred Selector
+	source    json.RawMessage
+}
+
+func (nodeSelector *NodeSelector) UnmarshalJSON(data []byte) error {
+	nodeSelector.source = append(json.RawMessage{}, data...)
+	var required string
+	if err := json.Unmarshal(data, &required); err == nil {
+		nodeSelector.Required, err = ParseSelector(required)
+		return err
+	}
+	expressions := struct {
+		Required  string `json:"required"`
+		Preferred string `json:"preferred"`
+	}{}
+	if err := json.Unmarshal(data, &expressions); err != nil {
+		return err
+	}
+	var err error
+	if nodeSelector.Required, err = ParseSelector(expressions.Required); err != nil {
+		return err
+	}
+	nodeSelector.Preferred, err = ParseSelector(expressions.Preferred)
+	return err
+}
+
+func (nodeSelector *NodeSelector) MarshalJSON() ([]byte, error) {
+	if nodeSelector.source == nil {
+		return []byte("null"), nil
+	}
+	return nodeSelector.source, nil
+}
+
+func parseRequirement(expression string) (requirement, error) {
+	if strings.HasPrefix(expression, "!") {
+		return requirement{strings.TrimSpace(expression[1:]), opNotExists, nil}, nil
+	}
+	if index := strings.Index(expression, "!="); index > 0 {
+		return requirement{strings.TrimSpace(expression[:index]), opNotEquals,
+			[]string{strings.TrimSpace(expression[index+2:])}}, nil
+	}
+	if index := strings.Index(expression, "="); index > 0 {
+		value := strings.TrimPrefix(expression[index+1:], "=")
+		return requirement{strings.TrimSpace(expression[:index]), opEquals, []string{strings.TrimSpace(value)}}, nil
+	}
+	fields := strings.Fields(expression)
+	if len(fields) == 1 {
+		return requirement{fields[0], opExists, nil}, nil
+	}
+	if len(fields) >= 2 {
+		op := strings.ToLower(fields[1])
+		list := strings.TrimSpace(strings.TrimPrefix(expression, fields[0]))
+		list = strings.TrimSpace(list[len(fields[1]):])
+		if (op == "in" || op == "notin") && strings.HasPrefix(list, "(") && strings.HasSuffix(list, ")") {
+			var values []string
+			for _, value := range strings.Split(list[1:len(list)-1], ",") {
+				values = append(values, strings.TrimSpace(value))
+			}
+			if op == "in" {
+				return requirement{fields[0], opIn, values}, nil
+			}
+			return requirement{fields[0], opNotIn, values}, nil
+		}
+	}
+	return requirement{}, fmt.Errorf("invalid node selector expression %q", expression)
+}
+
+// splitRequirements splits by commas which are not inside parentheses.
+func splitRequirements(expression string) []string {
+	var parts []string
+	var depth, start int
+	for index, char := range expression {
+		switch char {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, expression[start:index])
+				start = index + 1
+			}
+		}
+	}
+	return append(parts, expression[start:])
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
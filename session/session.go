@@ -6,22 +6,48 @@ package session
 
 import (
 	"time"
+	"sort"
+	"log/slog"
 )
 
 type Capabilities struct {
 	BrowserName     string   `json:"browserName"`
 	Version         property `json:"version"`
 	Platform        property `json:"platform"`
+	// Team is a tenant requested by a client.
+	Team            string   `json:"hub:team,omitempty"`
+	// Priority of a new session request. Higher priority requests are served first.
+	Priority        int      `json:"hub:priority,omitempty"`
+	// NodeSelector requires or prefers node labels.
+	NodeSelector    *NodeSelector `json:"hub:nodeSelector,omitempty"`
+	// Node pins a new session to the node with this id or URL.
+	Node            string   `json:"hub:node,omitempty"`
+}
+
+// LogValue makes capabilities compact in structured logs.
+func (capabilities Capabilities) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("browserName", capabilities.BrowserName),
+		slog.String("version", string(capabilities.Version)),
+		slog.String("platform", string(capabilities.Platform)))
 }
 
 type Session struct {
 	Id           string        `json:"id"`
 	Capabilities *Capabilities `json:"capabilities"`
+	// Owner is an identity of the client which created the session.
+	Owner        string        `json:"owner,omitempty"`
+	// Tenant whose quota the session uses.
+	Tenant       string        `json:"tenant,omitempty"`
+	// WebSockets are BiDi and CDP URLs of the session on the node by protocol: bidi or cdp.
+	WebSockets   map[string]string `json:"-"`
 	Status       uint8         `json:"-"`
 	Timer        *time.Timer   `json:"-"`
 	Node         *Node         `json:"-"`
 	queue        []*QueueElement
 	command      chan command
+	// stopped is closed when the slot exits, so commands sent later are dropped.
+	stopped      chan struct{}
 	action       func (*QueueElement)
 }
 
@@ -34,7 +60,7 @@ func (session *Session) start(element *QueueElement) {
 		element.Session <- session
 		close(element.Session)
 		session.action = session.registerElement
-		session.Status = Active
+		session.SetStatus(Active)
 	}
 }
 
@@ -44,26 +70,42 @@ func (session *Session) processor() {
 		case register:
 			element := (command.arguments).(*QueueElement)
 			session.action(element)
+		case unregister:
+			element := (command.arguments).(*QueueElement)
+			for index, queued := range session.queue {
+				if queued == element {
+					session.queue = append(session.queue[:index], session.queue[index+1:]...)
+					break
+				}
+			}
 		case exit:
-			session.Timer.Stop()
+			session.stopTimer()
 			session.queue = nil
-			close(session.command)
+			close(session.stopped)
+			return
 		case finish:
 			session.Id = ""
-			session.Timer.Stop()
+			session.Owner = ""
+			session.Tenant = ""
+			session.WebSockets = nil
+			session.stopTimer()
+			session.SetStatus(Available)
+			now := time.Now()
+			sort.SliceStable(session.queue, func(i, j int) bool {
+				return session.queue[i].EffectivePriority(now) > session.queue[j].EffectivePriority(now)
+			})
 			var position int
 			for index, element := range session.queue {
 				position = index + 1
 				if _, actual := <-element.Actual; actual {
 					element.Session <- session
 					close(element.Session)
-					session.Status = Active
+					session.SetStatus(Active)
 					break
 				}
 			}
-			session.Status = Available
 			session.queue = session.queue[position:]
-			if len(session.queue) == 0 {
+			if session.Status != Active {
 				session.action = session.start
 			}
 		case getWeight:
@@ -82,34 +124,63 @@ func (session *Session) processor() {
 	}
 }
 
+func (session *Session) stopTimer() {
+	if session.Timer != nil {
+		session.Timer.Stop()
+	}
+}
+
+// SetStatus changes the status under the lock of the node, so its load may be read concurrently.
+func (session *Session) SetStatus(status uint8) {
+	session.Node.locker.Lock()
+	session.Status = status
+	session.Node.locker.Unlock()
+}
+
+func (capabilities *Session) send(cmd command) {
+	select {
+	case capabilities.command <- cmd:
+	case <-capabilities.stopped:
+	}
+}
+
 func (capabilities *Session) Register(element *QueueElement) {
-	capabilities.command <- command{register, element}
+	capabilities.send(command{register, element})
+}
+
+// Unregister removes a request which does not wait for the slot anymore from its queue.
+func (capabilities *Session) Unregister(element *QueueElement) {
+	capabilities.send(command{unregister, element})
 }
 
 func (capabilities *Session) Finish() {
-	capabilities.command <- command{finish, nil}
+	capabilities.send(command{finish, nil})
 }
 
 func (capabilities *Session) Exit() {
-	capabilities.command <- command{exit, nil}
+	capabilities.send(command{exit, nil})
 }
 
 func (capabilities *Session) GetWeight() int {
-	weight := make(chan int)
-	defer close(weight)
-	capabilities.command <- command{getWeight, weight}
-	return <-weight
+	weight := make(chan int, 1)
+	capabilities.send(command{getWeight, weight})
+	select {
+	case scores := <-weight:
+		return scores
+	case <-capabilities.stopped:
+		return 0
+	}
 }
 
 func New(capabilities Capabilities, seleniumNode *Node) *Session {
 	var session *Session = new(Session)
-	seleniumNode.RegisterSession(session)
 	session.Capabilities = &capabilities
 	session.Status = Available
 	session.Node = seleniumNode
 	session.command = make(chan command, 10)
+	session.stopped = make(chan struct{})
 	session.action = session.start
-	session.Timer = new(time.Timer)
+	seleniumNode.RegisterSession(session)
 	go session.processor()
 	return session
 }
@@ -121,12 +192,24 @@ func (property property) Any() bool {
 }
 
 type QueueElement struct {
-	Actual  chan bool
-	Session chan *Session
+	Actual   chan bool
+	Session  chan *Session
+	Priority int
+	Enqueued time.Time
+	// Aging raises priority by one for every period of waiting, so low priority requests are not starved.
+	Aging    time.Duration
+}
+
+func (element *QueueElement) EffectivePriority(now time.Time) int {
+	if element.Aging <= 0 {
+		return element.Priority
+	}
+	return element.Priority + int(now.Sub(element.Enqueued)/element.Aging)
 }
 
 const (
 	register uint8 = iota
+	unregister
 	finish
 	getWeight
 	exit
@@ -4,25 +4,32 @@
  */
 package session
 
+// penaltyWeight moves slots of penalized nodes behind all other suitable slots.
+const penaltyWeight = 1000
+
 type SortedSessions struct {
-	Session *Session
-	Weight  int
+	Session  *Session
+	Weight   int
+	// Tiebreak orders sessions of the same weight by placement strategy.
+	Tiebreak float64
 }
 
 type CapabilitiesSorter struct {
 	sortedCapabilities  []*SortedSessions
 	desiredCapabilities *Capabilities
+	strategy            Strategy
 }
 
-func NewSorter(desiredCapabilities Capabilities) *CapabilitiesSorter {
+func NewSorter(desiredCapabilities Capabilities, strategy Strategy) *CapabilitiesSorter {
 	var cs *CapabilitiesSorter = new(CapabilitiesSorter)
 	cs.desiredCapabilities = &desiredCapabilities
+	cs.strategy = strategy
 	return cs
 }
 
 func (cs *CapabilitiesSorter) Add(session *Session) {
 	if weight, suitable := cs.weight(session); suitable {
-		forSort := SortedSessions{session, weight}
+		forSort := SortedSessions{session, weight, cs.strategy.Key(session)}
 		cs.sortedCapabilities = append(cs.sortedCapabilities, &forSort)
 	}
 }
@@ -35,6 +42,9 @@ func (cs *CapabilitiesSorter) weight(session *Session) (int, bool) {
 	dc := cs.desiredCapabilities
 	var scores int
 	capabilities := session.Capabilities
+	if dc.NodeSelector != nil && !dc.NodeSelector.Required.Matches(session.Node.Labels) {
+		return 0, false
+	}
 	if dc.BrowserName == capabilities.BrowserName {
 		if dc.Platform.Any() {
 			if capabilities.Platform.Any() {
@@ -66,7 +76,13 @@ func (cs *CapabilitiesSorter) weight(session *Session) (int, bool) {
 				return 0, false
 			}
 		}
+		if dc.NodeSelector != nil {
+			scores += dc.NodeSelector.Preferred.Mismatches(session.Node.Labels)
+		}
 		scores += session.GetWeight()
+		if session.Node.Penalized() {
+			scores += penaltyWeight
+		}
 		return scores, true
 	}
 	return 0, false
@@ -77,7 +93,10 @@ func (cs *CapabilitiesSorter) Len() int {
 }
 
 func (cs *CapabilitiesSorter) Less(i, j int) bool {
-	return cs.sortedCapabilities[i].Weight < cs.sortedCapabilities[j].Weight
+	if cs.sortedCapabilities[i].Weight != cs.sortedCapabilities[j].Weight {
+		return cs.sortedCapabilities[i].Weight < cs.sortedCapabilities[j].Weight
+	}
+	return cs.sortedCapabilities[i].Tiebreak < cs.sortedCapabilities[j].Tiebreak
 }
 
 func (cs *CapabilitiesSorter) Swap(i, j int) {
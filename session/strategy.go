@@ -0,0 +1,53 @@
+package session
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Strategy orders slots which match desired capabilities equally well. Slots with lower key are preferred.
+type Strategy interface {
+	Key(session *Session) float64
+}
+
+// NewStrategy returns a placement strategy by its name.
+func NewStrategy(name string) (Strategy, error) {
+	switch name {
+	case "", "least-busy":
+		return leastBusy{}, nil
+	case "bin-packing":
+		return binPacking{}, nil
+	case "round-robin":
+		return roundRobin{}, nil
+	case "random":
+		return random{}, nil
+	}
+	return nil, fmt.Errorf("unknown placement strategy %q", name)
+}
+
+// leastBusy prefers nodes with the lowest share of active sessions.
+type leastBusy struct{}
+
+func (leastBusy) Key(session *Session) float64 {
+	return session.Node.Load()
+}
+
+// binPacking prefers the busiest nodes, so other nodes stay free for large sessions or scale down.
+type binPacking struct{}
+
+func (binPacking) Key(session *Session) float64 {
+	return -session.Node.Load()
+}
+
+// roundRobin prefers nodes which were used least recently.
+type roundRobin struct{}
+
+func (roundRobin) Key(session *Session) float64 {
+	return float64(session.Node.LastUsed())
+}
+
+type random struct{}
+
+func (random) Key(session *Session) float64 {
+	return rand.Float64()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/tls"
+	"time"
+
+	"selenium-hub/certificate"
+	"selenium-hub/config"
+)
+
+// newServerTLS creates TLS configuration of the hub. Client certificates are requested
+// but not required, so WebDriver clients without them keep working.
+func newServerTLS(configuration *config.TLS) (*tls.Config, error) {
+	reloader, err := certificate.NewReloader(configuration.Certificate, configuration.Key,
+		configuration.ReloadInterval.Duration)
+	if err != nil {
+		return nil, err
+	}
+	serverTLS := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if configuration.ClientCA != "" {
+		pool, err := certificate.LoadPool(configuration.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		serverTLS.ClientCAs = pool
+		serverTLS.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return serverTLS, nil
+}
+
+// newNodeTLS creates TLS configuration for connections to https:// nodes.
+func newNodeTLS(configuration *config.NodeTLS) (*tls.Config, error) {
+	nodeTLS := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: configuration.InsecureSkipVerify,
+	}
+	if configuration.CA != "" {
+		pool, err := certificate.LoadPool(configuration.CA)
+		if err != nil {
+			return nil, err
+		}
+		nodeTLS.RootCAs = pool
+	}
+	if configuration.Certificate != "" {
+		reloader, err := certificate.NewReloader(configuration.Certificate, configuration.Key, time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		nodeTLS.GetClientCertificate = reloader.GetClientCertificate
+	}
+	return nodeTLS, nil
+}
@@ -7,8 +7,11 @@ package translator
 import (
 	"selenium-hub/session"
 	"encoding/json"
+	"encoding/base64"
 	"io/ioutil"
 	"io"
+	"bytes"
+	"strings"
 )
 
 type response struct {
@@ -35,6 +38,14 @@ type configuration struct {
 	MaxSession    uint8  `json:"maxSession"`
 	RegisterCycle uint32 `json:"registerCycle"`
 	Url           string `json:"url"`
+	// RegistrationSecret is checked by the hub and is never stored.
+	RegistrationSecret string `json:"registrationSecret,omitempty"`
+	// Labels are advertised by the node and matched by hub:nodeSelector capability.
+	Labels map[string]string `json:"labels,omitempty"`
+	// VncUrl is a WebSocket endpoint of a VNC server on the node, e.g. ws://node:7900/websockify.
+	VncUrl string `json:"vncUrl,omitempty"`
+	// BasePath is where the node serves WebDriver API: /wd/hub when empty, / for the root.
+	BasePath string `json:"basePath,omitempty"`
 }
 
 type Proxy struct {
@@ -51,6 +62,28 @@ type createSessionRequest struct {
 	DesiredCapabilities session.Capabilities `json:"desiredCapabilities"`
 }
 
+// w3cCreateSessionRequest is a new session request of W3C WebDriver clients.
+type w3cCreateSessionRequest struct {
+	Capabilities struct {
+		AlwaysMatch map[string]interface{}   `json:"alwaysMatch"`
+		FirstMatch  []map[string]interface{} `json:"firstMatch"`
+	} `json:"capabilities"`
+}
+
+// w3cCreateSessionAnswer is a new session answer of W3C WebDriver nodes.
+type w3cCreateSessionAnswer struct {
+	Value struct {
+		SessionID    string               `json:"sessionId"`
+		Capabilities session.Capabilities `json:"capabilities"`
+	} `json:"value"`
+}
+
+// webSocketCapabilities maps capabilities which hold WebSocket URLs of a node to hub endpoints.
+var webSocketCapabilities = map[string]string{
+	"webSocketUrl": "bidi",
+	"se:cdp":       "cdp",
+}
+
 func GetResponse(status uint8, value interface {}) ([]byte) {
 	data, _ := json.Marshal(response{nil, status, value})
 	return data
@@ -83,18 +116,91 @@ func setDefaults(machine *Proxy) {
 	machine.Configuration.Url = "http://localhost:4444/"
 }
 
+// BasePath returns a path prefix of WebDriver API of the node. It is empty for the root.
+func (machine *Proxy) BasePath() string {
+	switch machine.Configuration.BasePath {
+	case "":
+		return "/wd/hub"
+	case "/":
+		return ""
+	}
+	return "/" + strings.Trim(machine.Configuration.BasePath, "/")
+}
+
+// Endpoint returns URL of WebDriver API of the node.
+func (machine *Proxy) Endpoint() string {
+	return strings.TrimRight(machine.Configuration.Url, "/") + machine.BasePath()
+}
+
 func GetApiProxyResponseData(machine *Proxy) ([]byte) {
 	data, _ := json.Marshal(apiProxyResponse{*machine, true})
 	return data
 }
 
+// GetCreateSessionCapabilities reads desiredCapabilities of the JSON Wire protocol. When they do not name
+// a browser, the first W3C alternative merged with alwaysMatch is used.
 func GetCreateSessionCapabilities(data []byte) (*session.Capabilities, error) {
 	request := createSessionRequest{}
 	err := json.Unmarshal(data, &request)
 	if err != nil {
 		return nil, err
 	}
-	return &request.DesiredCapabilities, nil
+	if request.DesiredCapabilities.BrowserName != "" {
+		return &request.DesiredCapabilities, nil
+	}
+	merged, err := getW3CCapabilities(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(merged) == 0 {
+		return &request.DesiredCapabilities, nil
+	}
+	if version, found := merged["browserVersion"]; found {
+		merged["version"] = version
+	}
+	// W3C platform names are lower case, like "linux", while slots use "LINUX".
+	if platform, found := merged["platformName"].(string); found {
+		merged["platform"] = strings.ToUpper(platform)
+	}
+	mergedData, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	capabilities := &session.Capabilities{}
+	if err = json.Unmarshal(mergedData, capabilities); err != nil {
+		return nil, err
+	}
+	return capabilities, nil
+}
+
+// WantsWebSocket reports whether a new session request asks for a BiDi connection by webSocketUrl capability.
+func WantsWebSocket(data []byte) bool {
+	request := struct {
+		DesiredCapabilities map[string]interface{} `json:"desiredCapabilities"`
+	}{}
+	if json.Unmarshal(data, &request) == nil && request.DesiredCapabilities["webSocketUrl"] == true {
+		return true
+	}
+	merged, err := getW3CCapabilities(data)
+	return err == nil && merged["webSocketUrl"] == true
+}
+
+// getW3CCapabilities merges alwaysMatch with the first alternative of firstMatch.
+func getW3CCapabilities(data []byte) (map[string]interface{}, error) {
+	request := w3cCreateSessionRequest{}
+	if err := json.Unmarshal(data, &request); err != nil {
+		return nil, err
+	}
+	merged := make(map[string]interface{})
+	for name, value := range request.Capabilities.AlwaysMatch {
+		merged[name] = value
+	}
+	if len(request.Capabilities.FirstMatch) > 0 {
+		for name, value := range request.Capabilities.FirstMatch[0] {
+			merged[name] = value
+		}
+	}
+	return merged, nil
 }
 
 func GetCreateSessionRequestData(capabilities *session.Capabilities) ([]byte) {
@@ -107,8 +213,72 @@ func GetCreateSessionAnswerData(seleniumSession *session.Session) ([]byte) {
 	return data
 }
 
+// GetCreateSessionAnswer reads a new session answer of the JSON Wire protocol or W3C WebDriver.
 func GetCreateSessionAnswer(data []byte) (*CreateSessionAnswer) {
 	seleniumSession := &CreateSessionAnswer{}
 	json.Unmarshal(data, seleniumSession)
+	if seleniumSession.SessionID == "" {
+		w3cAnswer := w3cCreateSessionAnswer{}
+		if json.Unmarshal(data, &w3cAnswer) == nil && w3cAnswer.Value.SessionID != "" {
+			seleniumSession.SessionID = w3cAnswer.Value.SessionID
+			seleniumSession.Value = w3cAnswer.Value.Capabilities
+		}
+	}
 	return seleniumSession
 }
+
+// RewriteWebSocketUrls replaces WebSocket URLs of a node in a new session answer by sessionUrl/se/{protocol}.
+// It returns the answer and the original URLs by protocol: bidi or cdp.
+func RewriteWebSocketUrls(data []byte, sessionUrl string) ([]byte, map[string]string) {
+	var answer map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&answer); err != nil {
+		return data, nil
+	}
+	capabilities, _ := answer["value"].(map[string]interface{})
+	if nested, found := capabilities["capabilities"].(map[string]interface{}); found {
+		capabilities = nested
+	}
+	urls := make(map[string]string)
+	for name, protocol := range webSocketCapabilities {
+		if url, found := capabilities[name].(string); found && strings.HasPrefix(url, "ws") {
+			urls[protocol] = url
+			capabilities[name] = sessionUrl + "/se/" + protocol
+		}
+	}
+	if len(urls) == 0 {
+		return data, nil
+	}
+	rewritten, err := json.Marshal(answer)
+	if err != nil {
+		return data, nil
+	}
+	return rewritten, urls
+}
+
+// NewProxy creates a registration request for a WebDriver node with instances slots of the same capabilities.
+func NewProxy(url string, slot session.Capabilities, instances uint8) (*Proxy) {
+	machine := &Proxy{}
+	setDefaults(machine)
+	machine.Configuration.Url = url
+	machine.Configuration.MaxSession = instances
+	machine.Capabilities = []*capabilities{{slot, "WebDriver", instances}}
+	return machine
+}
+
+// NewSingleSlotProxy creates a registration request for a WebDriver node with only one slot.
+func NewSingleSlotProxy(url string, slot session.Capabilities) (*Proxy) {
+	return NewProxy(url, slot, 1)
+}
+
+// GetScreenshot decodes PNG from a screenshot command answer.
+func GetScreenshot(data []byte) ([]byte, error) {
+	answer := struct {
+		Value string `json:"value"`
+	}{}
+	if err := json.Unmarshal(data, &answer); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(answer.Value)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"selenium-hub/proxy"
+)
+
+// noVncUrl is an ES module of the noVNC client. The viewer is not served without it.
+var noVncUrl string
+
+// vncViewer shows the screen of a session. It is view only unless the page is opened with ?interactive.
+// Query parameters, e.g. access_token, are passed to the VNC WebSocket.
+var vncViewer = template.Must(template.New("vnc").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Session {{.Session}}</title>
+<style>html, body, #screen { margin: 0; width: 100%; height: 100%; background: #222; }</style>
+</head>
+<body>
+<div id="screen"></div>
+<script type="module">
+import RFB from {{.NoVncUrl}};
+const scheme = location.protocol === "https:" ? "wss://" : "ws://";
+const url = scheme + location.host + location.pathname.replace(/\/view$/, "") + location.search;
+const rfb = new RFB(document.getElementById("screen"), url);
+rfb.viewOnly = !new URLSearchParams(location.search).has("interactive");
+rfb.scaleViewport = true;
+rfb.addEventListener("credentialsrequired", () => rfb.sendCredentials({password: prompt("VNC password")}));
+rfb.addEventListener("disconnect", () => document.title = "Session {{.Session}} is disconnected");
+</script>
+</body>
+</html>
+`))
+
+// httpVnc proxies the VNC WebSocket of the node which runs the session.
+func httpVnc(w http.ResponseWriter, r *http.Request) {
+	sessionId := mux.Vars(r)["session"]
+	target, found := seleniumHub.GetSessionVnc(sessionId)
+	if !found {
+		log.WarnContext(r.Context(), "VNC of session not found", "session", sessionId)
+		http.NotFound(w, r)
+		return
+	}
+	log.InfoContext(r.Context(), "Proxy VNC", "session", sessionId, "target", target)
+	if err := proxy.ProxyWebSocket(w, r, target, nil); err != nil {
+		log.ErrorContext(r.Context(), "Could not proxy VNC", "session", sessionId, "target", target, "error", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+func httpVncViewer(w http.ResponseWriter, r *http.Request) {
+	sessionId := mux.Vars(r)["session"]
+	if noVncUrl == "" {
+		log.WarnContext(r.Context(), "VNC viewer is disabled, noVncUrl is not configured", "session", sessionId)
+		http.NotFound(w, r)
+		return
+	}
+	if _, found := seleniumHub.GetSessionVnc(sessionId); !found {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html;charset=UTF-8")
+	vncViewer.Execute(w, struct {
+		Session  string
+		NoVncUrl string
+	}{sessionId, noVncUrl})
+}
@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"selenium-hub/config"
+	"selenium-hub/hub"
+	"selenium-hub/logger"
+)
+
+var log = logger.For("webhook")
+
+// queueSize is how many events may wait for delivery before new ones are dropped.
+const queueSize = 1024
+
+// SignatureHeader carries "sha256=" and hex HMAC-SHA256 of the body signed by the webhook secret.
+const SignatureHeader = "X-Hub-Signature-256"
+
+// Webhook delivers hub events to an URL in the order they happened.
+type Webhook struct {
+	configuration *config.Webhook
+	events        map[string]bool
+	queue         chan hub.Event
+	client        *http.Client
+}
+
+func New(configuration *config.Webhook) *Webhook {
+	var webhook *Webhook = new(Webhook)
+	webhook.configuration = configuration
+	webhook.events = make(map[string]bool)
+	for _, eventType := range configuration.Events {
+		webhook.events[eventType] = true
+	}
+	webhook.queue = make(chan hub.Event, queueSize)
+	webhook.client = &http.Client{Timeout: configuration.Timeout.Duration}
+	go webhook.deliver()
+	return webhook
+}
+
+// Notify queues the event for delivery. It is a hub.EventListener.
+func (webhook *Webhook) Notify(event hub.Event) {
+	if len(webhook.events) > 0 && !webhook.events[event.Type] {
+		return
+	}
+	select {
+	case webhook.queue <- event:
+	default:
+		log.Warn("Webhook queue is full, event is dropped", "url", webhook.configuration.Url, "event", event.Type)
+	}
+}
+
+func (webhook *Webhook) deliver() {
+	for event := range webhook.queue {
+		data, _ := json.Marshal(event)
+		backoff := time.Second
+		for attempt := 0; ; attempt++ {
+			err := webhook.send(data)
+			if err == nil {
+				break
+			}
+			if attempt >= webhook.configuration.Retries {
+				log.Error("Could not deliver event", "url", webhook.configuration.Url, "event", event.Type,
+					"attempts", attempt+1, "error", err)
+				break
+			}
+			log.Warn("Retry event delivery", "url", webhook.configuration.Url, "event", event.Type, "error", err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func (webhook *Webhook) send(data []byte) error {
+	request, err := http.NewRequest("POST", webhook.configuration.Url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "go-selenium-hub")
+	if webhook.configuration.Secret != "" {
+		request.Header.Set(SignatureHeader, "sha256="+Sign(webhook.configuration.Secret, data))
+	}
+	response, err := webhook.client.Do(request)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %d", response.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns hex HMAC-SHA256 of data.
+func Sign(secret string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}